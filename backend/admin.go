@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"math/rand"
+	"strings"
+)
+
+// user ids allowed to call admin RPCs, set from TTT_ADMIN_USER_IDS in InitModule
+var adminIDs = map[string]bool{}
+
+// returned by admin RPCs to everyone else
+var errAdminOnly = errors.New("admin only")
+
+// helper: admin RPCs are allowed for server-to-server calls (no user in ctx) and configured admin users
+func isAdmin(ctx context.Context) bool {
+	userID, _ := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	return userID == "" || adminIDs[userID]
+}
+
+// helper: parse a comma separated list of admin user ids
+func parseAdminIDs(v string) map[string]bool {
+	ids := map[string]bool{}
+	for _, id := range strings.Split(v, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// adminSetCellRPC: set or clear any cell regardless of turn, for fixing disputed games.
+// Expects {"game_id":"...","cell":index,"mark":"X"}, an empty mark or "-" clears the cell.
+func adminSetCellRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if !isAdmin(ctx) {
+		return "", errAdminOnly
+	}
+
+	var req AdminSetCellRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+	gid, cell, mark := req.GameID, int(*req.Cell), req.Mark
+	if mark == "" {
+		mark = "-"
+	}
+
+	game, err := lockGame(gid)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	if !validBoard(game.Board) {
+		return "", errors.New("corrupt board")
+	}
+	if err := checkCellRange(cell, len(game.Board)); err != nil {
+		return "", err
+	}
+	if mark != "-" && !containsString(game.Marks, mark) {
+		return "", errors.New("invalid mark")
+	}
+
+	wasFinished := game.Winner != ""
+	boardRunes := []rune(game.Board)
+	boardRunes[cell] = rune(mark[0])
+	game.Board = string(boardRunes)
+	// a result decided off the board stands whatever the cells now say
+	finished := wasFinished
+	if !offBoardResult(game) {
+		finished = settleResult(game)
+	}
+	game.Version++
+
+	actor := callerID(ctx)
+	addEvent(game, Event{Type: "set_cell", Actor: actor, Cell: &cell, Mark: mark})
+	if finished && !wasFinished {
+		addFinishEvent(game)
+	}
+	game.Audit = append(game.Audit, AuditEntry{
+		Action:  "set_cell",
+		Actor:   actor,
+		Cell:    cell,
+		Mark:    mark,
+		Version: game.Version,
+		At:      nowMs(),
+	})
+	logger.WithFields(map[string]interface{}{"game_id": game.ID, "cell": cell, "mark": mark, "actor": actor}).Info("admin set cell")
+	broadcastGame(logger, nk, game)
+
+	resp := map[string]interface{}{
+		"ok":   true,
+		"game": game,
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// helper: whether the game ended on time, by forfeit or by an accepted auto-draw rather than on the board
+func offBoardResult(game *Game) bool {
+	switch game.WinKind {
+	case "timeout", "forfeit", "auto_draw":
+		return true
+	}
+	return false
+}
+
+// game states seed_games can create, in the order they're created
+var seedStates = []string{"waiting", "in_progress", "finished"}
+
+// most games one seed_games call may create
+const maxSeedGames = 100
+
+// helper: a random empty cell, skipping cells that would end the game when keepOpen is set.
+// Returns -1 when there's no such cell.
+func randomOpenCell(game *Game, keepOpen bool) int {
+	cells := []int{}
+	for cell := 0; cell < len(game.Board); cell++ {
+		if playable(game, game.Board, cell) && !(keepOpen && moveEndsGame(game, cell)) {
+			cells = append(cells, cell)
+		}
+	}
+	if len(cells) == 0 {
+		return -1
+	}
+	return cells[rand.Intn(len(cells))]
+}
+
+// helper: a new game played out with random moves to reach state. It's demo data, so the
+// moves are replayed: no game-end hooks and no moves_served.
+func seedGame(state, actor string) *Game {
+	game, _ := newGame(&CreateGameRequest{}, actor)
+	game.replaying = true
+	defer func() { game.replaying = false }()
+	switch state {
+	case "in_progress":
+		for moves := 1 + rand.Intn(4); moves > 0; moves-- {
+			cell := randomOpenCell(game, true)
+			if cell < 0 {
+				break
+			}
+			applyMove(game, cell, actor)
+		}
+	case "finished":
+		for game.Winner == "" {
+			applyMove(game, randomOpenCell(game, false), actor)
+		}
+	}
+	return game
+}
+
+// seedGamesRPC: create demo games in the requested states with random moves, expects
+// {"counts":{"waiting":2,"in_progress":3,"finished":5}}. Returns the new game ids by state.
+func seedGamesRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if !isAdmin(ctx) {
+		return "", errAdminOnly
+	}
+
+	var req SeedGamesRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	actor := callerID(ctx)
+	created := map[string][]string{}
+	for _, state := range seedStates {
+		ids := []string{}
+		for i := 0; i < req.Counts[state]; i++ {
+			game := seedGame(state, actor)
+			storeGame(game)
+			ids = append(ids, game.ID)
+		}
+		created[state] = ids
+	}
+	logger.WithFields(map[string]interface{}{"counts": req.Counts, "actor": actor}).Info("admin seeded games")
+
+	resp := map[string]interface{}{
+		"ok":    true,
+		"games": created,
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
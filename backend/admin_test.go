@@ -0,0 +1,123 @@
+package main
+
+import "testing"
+
+func TestAdminSetCellIsAdminOnly(t *testing.T) {
+	setupTest(t)
+	setValue(t, &adminIDs, map[string]bool{"admin": true})
+	gid := createGame(t, `{}`)
+
+	if _, err := callRPCWith(t, userContext("player", "s1"), nil, adminSetCellRPC, gameRequest(gid, `"cell":4`, `"mark":"O"`)); err != errAdminOnly {
+		t.Fatalf("non-admin: err = %v, want admin only", err)
+	}
+	if _, err := callRPCWith(t, userContext("admin", "s2"), nil, adminSetCellRPC, gameRequest(gid, `"cell":4`, `"mark":"O"`)); err != nil {
+		t.Fatalf("admin: %v", err)
+	}
+
+	game := gameState(t, gid)
+	if game.Board != "----O----" {
+		t.Fatalf("board = %s", game.Board)
+	}
+	if len(game.Audit) != 1 || game.Audit[0].Actor != "admin" || game.Audit[0].Cell != 4 || game.Audit[0].Mark != "O" {
+		t.Fatalf("audit = %+v", game.Audit)
+	}
+}
+
+func TestAdminSetCellSettlesTheResult(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4)
+
+	mustRPC(t, adminSetCellRPC, gameRequest(gid, `"cell":2`, `"mark":"X"`))
+	if game := gameState(t, gid); game.Winner != "X" {
+		t.Fatalf("winner = %q, want X after completing the top row", game.Winner)
+	}
+
+	mustRPC(t, adminSetCellRPC, gameRequest(gid, `"cell":2`))
+	if game := gameState(t, gid); game.Winner != "" || game.Board != "XX-OO----" {
+		t.Fatalf("winner %q board %s after clearing the cell", game.Winner, game.Board)
+	}
+}
+
+func TestAdminSetCellKeepsTimeoutResult(t *testing.T) {
+	setupTest(t)
+	ended := 0
+	setValue(t, &gameEndHooks, []func(*Game){func(*Game) { ended++ }})
+	advance := setClock(t, 1000)
+	gid := createGame(t, `{"clock_seconds":5}`)
+	playCells(t, gid, 4)
+	advance(6000)
+	mustRPC(t, getGameRPC, gameRequest(gid))
+
+	// completing a row for O doesn't undo X's win on time, nor finish the game a second time
+	mustRPC(t, adminSetCellRPC, gameRequest(gid, `"cell":0`, `"mark":"O"`))
+	mustRPC(t, adminSetCellRPC, gameRequest(gid, `"cell":1`, `"mark":"O"`))
+	mustRPC(t, adminSetCellRPC, gameRequest(gid, `"cell":2`, `"mark":"O"`))
+	mustRPC(t, adminSetCellRPC, gameRequest(gid, `"cell":4`))
+
+	game := gameState(t, gid)
+	if game.Winner != "X" || game.WinKind != "timeout" || game.Board != "OOO------" {
+		t.Fatalf("winner %q by %q board %s, want the timeout to stand", game.Winner, game.WinKind, game.Board)
+	}
+	if ended != 1 {
+		t.Fatalf("game-end hooks ran %d times, want 1", ended)
+	}
+}
+
+func TestSeedGamesReachTheirStates(t *testing.T) {
+	setupTest(t)
+	resp := mustRPC(t, seedGamesRPC, `{"counts":{"waiting":2,"in_progress":5,"finished":5}}`)
+	created := resp["games"].(map[string]interface{})
+
+	for state, ids := range created {
+		for _, id := range ids.([]interface{}) {
+			game := gameState(t, id.(string))
+			moves := len(game.History)
+			switch {
+			case state == "waiting" && moves != 0,
+				state == "in_progress" && (moves == 0 || game.Winner != ""),
+				state == "finished" && game.Winner == "":
+				t.Errorf("%s game %s: %d moves, winner %q", state, game.ID, moves, game.Winner)
+			}
+		}
+	}
+	if n := len(created["in_progress"].([]interface{})); n != 5 {
+		t.Fatalf("%d in_progress games, want 5", n)
+	}
+
+	if _, err := callRPC(t, seedGamesRPC, `{"counts":{"paused":1}}`); err == nil || err.Error() != `invalid state "paused"` {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestAdminAuditUsesTheGameClock(t *testing.T) {
+	setupTest(t)
+	advance := setClock(t, 1000)
+	gid := createGame(t, `{}`)
+	advance(500)
+	mustRPC(t, adminSetCellRPC, gameRequest(gid, `"cell":4`, `"mark":"O"`))
+
+	game := gameState(t, gid)
+	last := game.Events[len(game.Events)-1]
+	if game.Audit[0].At != 1500 || last.Type != "set_cell" || last.At != game.Audit[0].At {
+		t.Fatalf("audit at %d, set_cell event at %d, want both 1500", game.Audit[0].At, last.At)
+	}
+}
+
+func TestSeedGamesAreQuiet(t *testing.T) {
+	setupTest(t)
+	ended := recordGameEnds(t)
+	before := movesServed.Load()
+
+	mustRPC(t, seedGamesRPC, `{"counts":{"in_progress":3,"finished":3}}`)
+	if len(*ended) != 0 || movesServed.Load() != before {
+		t.Fatalf("seeding ran %d game-end hooks and served %d moves", len(*ended), movesServed.Load()-before)
+	}
+	// the seeded games play on as usual
+	resp := mustRPC(t, seedGamesRPC, `{"counts":{"waiting":1}}`)
+	gid := resp["games"].(map[string]interface{})["waiting"].([]interface{})[0].(string)
+	playCells(t, gid, 0, 3, 1, 4, 2)
+	if len(*ended) != 1 || movesServed.Load() != before+5 {
+		t.Fatalf("%d game-end hooks, %d moves served after playing a seeded game", len(*ended), movesServed.Load()-before)
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"sort"
+	"strings"
+)
+
+// Game-tree analysis for two-player games. The board is small enough to search
+// exhaustively, so every score here is exact.
+
+// RankedMove is one legal move with its minimax score for the side to play
+type RankedMove struct {
+	Cell    int    `json:"cell"`
+	Score   int    `json:"score"`   // > 0 forced win, 0 draw, < 0 forced loss; quicker results are further from 0
+	Outcome string `json:"outcome"` // "winning", "drawing", "losing" or "blunder"
+}
+
+// helper: the opponent's mark in a two-player game
+func otherMark(game *Game, mark string) string {
+	if mark == game.Marks[0] {
+		return game.Marks[1]
+	}
+	return game.Marks[0]
+}
+
+// helper: result of a board under the game's rules, "" while it's still in play.
+// Same precedence as settleResult: a completed line wins even on a full board.
+func boardResult(game *Game, board string) string {
+	if winner, _, _ := checkWinner(game, board); winner != "" {
+		return winner
+	}
+	if !strings.Contains(board, "-") {
+		if game.NoDraw {
+			return tieBreakWinner(board)
+		}
+		return "draw"
+	}
+	return ""
+}
+
+// helper: analysis only works on a live, valid two-player game
+func checkAnalyzable(game *Game) error {
+	if !validBoard(game.Board) {
+		return errors.New("corrupt board")
+	}
+	if game.Winner != "" {
+		return errGameFinished
+	}
+	if len(game.Marks) != 2 {
+		return errors.New("analysis needs a two-player game")
+	}
+	return nil
+}
+
+// solve: minimax value of board with turn to move, from the mover's point of view
+func solve(game *Game, board, turn string, memo map[string]int) int {
+	key := board + turn
+	if v, ok := memo[key]; ok {
+		return v
+	}
+	best := -100
+	for cell := 0; cell < len(board); cell++ {
+		if !playable(game, board, cell) {
+			continue
+		}
+		if s := moveScore(game, board, turn, cell, memo); s > best {
+			best = s
+		}
+	}
+	memo[key] = best
+	return best
+}
+
+// moveScore: minimax value of turn playing cell on board
+func moveScore(game *Game, board, turn string, cell int, memo map[string]int) int {
+	next := board[:cell] + turn + board[cell+1:]
+	s := 0
+	switch result := boardResult(game, next); result {
+	case turn:
+		s = 10
+	case "draw":
+		s = 0
+	case "":
+		s = -solve(game, next, otherMark(game, turn), memo)
+	default:
+		s = -10
+	}
+	// results further away count for less, so quick wins and slow losses are preferred
+	if s > 0 {
+		s--
+	} else if s < 0 {
+		s++
+	}
+	return s
+}
+
+// helper: how natural a cell is to play, lower first: the center, then corners, then edges
+func cellPreference(cell int) int {
+	r, c := cell/boardSize, cell%boardSize
+	switch {
+	case r == boardSize/2 && c == boardSize/2:
+		return 0
+	case (r == 0 || r == boardSize-1) && (c == 0 || c == boardSize-1):
+		return 1
+	}
+	return 2
+}
+
+// rankMoves: every legal move for the side to play, best first. Ties go by aiTieBreak,
+// and by lowest cell after that.
+func rankMoves(game *Game) []RankedMove {
+	memo := map[string]int{}
+	moves := []RankedMove{}
+	best := -100
+	for cell := 0; cell < len(game.Board); cell++ {
+		if !playable(game, game.Board, cell) {
+			continue
+		}
+		s := moveScore(game, game.Board, game.Turn, cell, memo)
+		if s > best {
+			best = s
+		}
+		moves = append(moves, RankedMove{Cell: cell, Score: s})
+	}
+	sort.SliceStable(moves, func(i, j int) bool {
+		if moves[i].Score != moves[j].Score {
+			return moves[i].Score > moves[j].Score
+		}
+		return aiTieBreak == "natural" && cellPreference(moves[i].Cell) < cellPreference(moves[j].Cell)
+	})
+
+	for i := range moves {
+		switch s := moves[i].Score; {
+		case s > 0:
+			moves[i].Outcome = "winning"
+		case s == 0:
+			moves[i].Outcome = "drawing"
+		case best >= 0:
+			// losing when a win or draw was still available
+			moves[i].Outcome = "blunder"
+		default:
+			moves[i].Outcome = "losing"
+		}
+	}
+	return moves
+}
+
+// predictOutcome: result under perfect play from here (a mark, "draw", or "unknown" when the
+// game can't be analysed) and the confidence in it. Exhaustive search makes it exact.
+func predictOutcome(game *Game) (string, float64) {
+	if game.Winner != "" {
+		return game.Winner, 1
+	}
+	if checkAnalyzable(game) != nil {
+		return "unknown", 0
+	}
+	switch v := solve(game, game.Board, game.Turn, map[string]int{}); {
+	case v > 0:
+		return game.Turn, 1
+	case v < 0:
+		return otherMark(game, game.Turn), 1
+	}
+	return "draw", 1
+}
+
+// positionEval: minimax score of the current position for each mark, same scale as RankedMove.Score.
+// nil when the game can't be analysed.
+func positionEval(game *Game) map[string]int {
+	if checkAnalyzable(game) != nil {
+		return nil
+	}
+	v := solve(game, game.Board, game.Turn, map[string]int{})
+	return map[string]int{
+		game.Turn:                  v,
+		otherMark(game, game.Turn): -v,
+	}
+}
+
+// getGameWithEtaRPC: the game plus its predicted outcome, expects {"game_id":"..."}
+func getGameWithEtaRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	// search a copy so the game isn't locked for the whole analysis
+	live, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	game := live.Clone()
+	live.mu.Unlock()
+	if !validBoard(game.Board) {
+		return "", errors.New("corrupt board")
+	}
+
+	prediction, confidence := predictOutcome(game)
+	resp := map[string]interface{}{
+		"ok":         true,
+		"game":       game,
+		"prediction": prediction,
+		"confidence": confidence,
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// canStillWin: whether any continuation at all, good or bad, gives someone a win
+func canStillWin(game *Game, board, turn string, memo map[string]bool) bool {
+	key := board + turn
+	if v, ok := memo[key]; ok {
+		return v
+	}
+	win := false
+	for cell := 0; cell < len(board) && !win; cell++ {
+		if !playable(game, board, cell) {
+			continue
+		}
+		next := board[:cell] + turn + board[cell+1:]
+		switch result := boardResult(game, next); result {
+		case "draw":
+		case "":
+			win = canStillWin(game, next, otherMark(game, turn), memo)
+		default:
+			win = true
+		}
+	}
+	memo[key] = win
+	return win
+}
+
+// forcedDraw: whether every way the game can continue ends in a draw
+func forcedDraw(game *Game) bool {
+	return checkAnalyzable(game) == nil && !canStillWin(game, game.Board, game.Turn, map[string]bool{})
+}
+
+// acceptAutoDrawRPC: agree to end a dead-drawn game now, expects {"game_id":"...","mark":"X"}.
+// The game is drawn once every player has accepted; any move in between resets the agreement.
+func acceptAutoDrawRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req AcceptAutoDrawRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	if !containsString(game.Marks, req.Mark) {
+		return "", errors.New("invalid mark")
+	}
+	if !forcedDraw(game) {
+		return "", errors.New("no forced draw")
+	}
+	if err := claimSeat(ctx, game, req.Mark); err != nil {
+		return "", err
+	}
+
+	if !containsString(game.DrawAccepts, req.Mark) {
+		game.DrawAccepts = append(game.DrawAccepts, req.Mark)
+	}
+	// the bot always takes a dead draw
+	if game.BotMark != "" && !containsString(game.DrawAccepts, game.BotMark) {
+		game.DrawAccepts = append(game.DrawAccepts, game.BotMark)
+	}
+	if len(game.DrawAccepts) == len(game.Marks) {
+		game.Winner = "draw"
+		game.WinKind = "auto_draw"
+		game.Version++
+		addEvent(game, Event{Type: "auto_draw", Actor: callerID(ctx)})
+		runGameEndHooks(game)
+		broadcastGame(logger, nk, game)
+	}
+
+	resp := map[string]interface{}{
+		"ok":       true,
+		"accepted": game.DrawAccepts,
+		"game":     game,
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// helper: the "mirror" bot's move, the cell opposite the last move through the center,
+// or the first playable cell when that's taken or nothing has been played yet
+func mirrorMove(game *Game) int {
+	if n := len(game.History); n > 0 {
+		if cell := len(game.Board) - 1 - game.History[n-1].Cell; playable(game, game.Board, cell) {
+			return cell
+		}
+	}
+	for cell := range game.Board {
+		if playable(game, game.Board, cell) {
+			return cell
+		}
+	}
+	return -1
+}
+
+// playBotTurn: if it's the practice bot's turn, play its move for the game's bot level.
+// Caller must hold game.mu.
+func playBotTurn(logger runtime.Logger, game *Game) {
+	if game.BotMark == "" || game.Turn != game.BotMark || checkAnalyzable(game) != nil {
+		return
+	}
+	var cell int
+	if game.BotLevel == "mirror" {
+		cell = mirrorMove(game)
+	} else {
+		cell = rankMoves(game)[0].Cell
+	}
+	if err := applyMove(game, cell, ""); err != nil {
+		logger.WithField("game_id", game.ID).Error("Bot move rejected: %v", err)
+		return
+	}
+	logMoveAnalytics(logger, game)
+}
+
+// rankMovesRPC: all legal moves ranked best to worst for the side to play, expects {"game_id":"..."}
+func rankMovesRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	// search a copy so the game isn't locked for the whole analysis
+	live, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	game := live.Clone()
+	live.mu.Unlock()
+	if err := checkAnalyzable(game); err != nil {
+		return "", err
+	}
+
+	resp := map[string]interface{}{
+		"ok":    true,
+		"turn":  game.Turn,
+		"moves": rankMoves(game),
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// helper: the lines the game is won on, its custom ones or the standard rows, columns and diagonals
+func gameLines(game *Game) [][]int {
+	if game.WinLines != nil {
+		return game.WinLines
+	}
+	lines := make([][]int, len(winLines))
+	for i, w := range winLines {
+		lines[i] = w.cells
+	}
+	return lines
+}
+
+// helper: cells each mark could play to complete a line right away, lowest first. Scans for
+// lines that are one empty cell short of being filled by a single mark, no search needed.
+func lineThreats(game *Game) map[string][]int {
+	found := map[string]map[int]bool{}
+	for _, line := range gameLines(game) {
+		mark, empty := "", -1
+		for _, cell := range line {
+			c := string(game.Board[cell])
+			switch {
+			case c == "-" && empty == -1:
+				empty = cell
+			case c == "-":
+				empty = -2 // more than one cell missing
+			case mark == "":
+				mark = c
+			case c != mark:
+				mark = "-" // mixed marks, nobody completes it
+			}
+		}
+		if empty < 0 || mark == "" || mark == "-" || !playable(game, game.Board, empty) {
+			continue
+		}
+		if found[mark] == nil {
+			found[mark] = map[int]bool{}
+		}
+		found[mark][empty] = true
+	}
+	threats := map[string][]int{}
+	for _, m := range game.Marks {
+		cells := []int{}
+		for cell := range game.Board {
+			if found[m][cell] {
+				cells = append(cells, cell)
+			}
+		}
+		threats[m] = cells
+	}
+	return threats
+}
+
+// helper: cells where mark would complete a line with its next move
+func immediateWins(game *Game, mark string) []int {
+	return lineThreats(game)[mark]
+}
+
+// getWinningMovesRPC: light hint for the side to play, expects {"game_id":"..."}. Returns the moves
+// that win on the spot, or failing that the ones that stop the next player winning on the spot.
+func getWinningMovesRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	if !validBoard(game.Board) {
+		return "", errors.New("corrupt board")
+	}
+	if game.Winner != "" {
+		return "", errGameFinished
+	}
+
+	kind, cells := "win", immediateWins(game, game.Turn)
+	if len(cells) == 0 {
+		kind, cells = "block", immediateWins(game, nextMark(game))
+	}
+	if len(cells) == 0 {
+		kind = "none"
+	}
+
+	resp := map[string]interface{}{
+		"ok":    true,
+		"turn":  game.Turn,
+		"kind":  kind,
+		"cells": cells,
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// getWinningCellsRPC: for a teaching overlay, whether each player could win with one move right
+// now and where, expects {"game_id":"..."}. Unlike get_winning_moves it ignores whose turn it is.
+func getWinningCellsRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	if !validBoard(game.Board) {
+		return "", errors.New("corrupt board")
+	}
+	if game.Winner != "" {
+		return "", errGameFinished
+	}
+
+	players := map[string]interface{}{}
+	for mark, cells := range lineThreats(game) {
+		entry := map[string]interface{}{"can_win": len(cells) > 0, "cells": cells}
+		if len(cells) > 0 {
+			entry["cell"] = cells[0]
+		}
+		players[mark] = entry
+	}
+
+	resp := map[string]interface{}{
+		"ok":      true,
+		"turn":    game.Turn,
+		"players": players,
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
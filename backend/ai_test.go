@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRankMovesFindsWinAndBlunders(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4)
+
+	resp := mustRPC(t, rankMovesRPC, gameRequest(gid))
+	moves := resp["moves"].([]interface{})
+	if len(moves) != 5 {
+		t.Fatalf("moves = %v, want the 5 empty cells", moves)
+	}
+	best := moves[0].(map[string]interface{})
+	if best["cell"] != 2.0 || best["score"] != 9.0 || best["outcome"] != "winning" {
+		t.Fatalf("best move = %v, want the immediate win on 2", best)
+	}
+	for _, m := range moves[1:] {
+		if m := m.(map[string]interface{}); m["cell"] != 5.0 && m["outcome"] != "blunder" {
+			t.Errorf("move %v: outcome %v, leaving O the middle row should be a blunder", m["cell"], m["outcome"])
+		}
+	}
+}
+
+func TestRankMovesNeedsTwoPlayers(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"players":3}`)
+	if _, err := callRPC(t, rankMovesRPC, gameRequest(gid)); err == nil || err.Error() != "analysis needs a two-player game" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestGameWithEtaPredictsOutcome(t *testing.T) {
+	setupTest(t)
+	cases := []struct {
+		options    string
+		cells      []int
+		prediction string
+		confidence float64
+	}{
+		{`{}`, nil, "draw", 1},
+		{`{}`, []int{0, 1}, "X", 1},
+		{`{}`, []int{0, 3, 1, 4, 2}, "X", 1},
+		{`{"players":3}`, nil, "unknown", 0},
+	}
+	for _, c := range cases {
+		gid := createGame(t, c.options)
+		playCells(t, gid, c.cells...)
+		resp := mustRPC(t, getGameWithEtaRPC, gameRequest(gid))
+		if resp["prediction"] != c.prediction || resp["confidence"] != c.confidence {
+			t.Errorf("%s after %v: prediction %v (%v), want %s (%v)", c.options, c.cells, resp["prediction"], resp["confidence"], c.prediction, c.confidence)
+		}
+	}
+}
+
+func TestAutoDrawNeedsEveryPlayer(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 1, 2, 4, 3, 5, 7)
+	if _, err := callRPC(t, acceptAutoDrawRPC, gameRequest(gid, `"mark":"X"`)); err == nil || err.Error() != "no forced draw" {
+		t.Fatalf("X can still win on 6: err = %v", err)
+	}
+
+	// only 8 is left and it can't complete a line
+	playCells(t, gid, 6)
+	if resp := mustRPC(t, getGameRPC, gameRequest(gid)); resp["auto_draw_available"] != true {
+		t.Fatalf("get_game = %v, want auto_draw_available", resp)
+	}
+	mustRPC(t, acceptAutoDrawRPC, gameRequest(gid, `"mark":"X"`))
+	if game := gameState(t, gid); game.Winner != "" {
+		t.Fatalf("drawn on one acceptance: winner %q", game.Winner)
+	}
+	mustRPC(t, acceptAutoDrawRPC, gameRequest(gid, `"mark":"O"`))
+	if game := gameState(t, gid); game.Winner != "draw" || game.WinKind != "auto_draw" {
+		t.Fatalf("winner %q by %q, want an auto_draw", game.Winner, game.WinKind)
+	}
+}
+
+func TestBotRepliesAndNeverLoses(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"vs_bot":true}`)
+
+	for game := gameState(t, gid); game.Winner == ""; game = gameState(t, gid) {
+		if game.Turn != "X" || strings.Count(game.Board, "X") != strings.Count(game.Board, "O") {
+			t.Fatalf("bot didn't reply: board %s turn %s", game.Board, game.Turn)
+		}
+		playCells(t, gid, strings.Index(game.Board, "-"))
+	}
+	if game := gameState(t, gid); game.Winner == "X" {
+		t.Fatalf("the bot lost: %s", game.Board)
+	}
+}
+
+func TestIncludeEval(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 1)
+
+	resp := mustRPC(t, getGameRPC, gameRequest(gid, `"include_eval":true`))
+	eval := resp["eval"].(map[string]interface{})
+	if eval["X"].(float64) <= 0 || eval["O"] != -eval["X"].(float64) {
+		t.Fatalf("eval = %v, want X winning", eval)
+	}
+	if _, ok := mustRPC(t, getGameRPC, gameRequest(gid))["eval"]; ok {
+		t.Fatal("eval sent without include_eval")
+	}
+
+	playCells(t, gid, 3, 4, 6)
+	if _, ok := mustRPC(t, getGameRPC, gameRequest(gid, `"include_eval":true`))["eval"]; ok {
+		t.Fatal("eval sent for a finished game")
+	}
+}
+
+func TestAITieBreak(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	for tieBreak, want := range map[string]string{"lowest_index": "[0 1 2 3 4 5 6 7 8]", "natural": "[4 0 2 6 8 1 3 5 7]"} {
+		setValue(t, &aiTieBreak, tieBreak)
+		var cells []int
+		for _, m := range rankMoves(gameState(t, gid)) {
+			cells = append(cells, m.Cell)
+		}
+		// every opening move draws, so the order is the tie-break alone
+		if fmt.Sprint(cells) != want {
+			t.Errorf("%s: order %v, want %s", tieBreak, cells, want)
+		}
+	}
+}
+
+func TestWinningMoves(t *testing.T) {
+	setupTest(t)
+	cases := []struct {
+		cells []int
+		kind  string
+		want  string
+	}{
+		{nil, "none", "[]"},
+		{[]int{0, 3, 1, 4}, "win", "[2]"},
+		{[]int{0, 3, 8, 4}, "block", "[5]"},
+	}
+	for _, c := range cases {
+		gid := createGame(t, `{}`)
+		playCells(t, gid, c.cells...)
+		resp := mustRPC(t, getWinningMovesRPC, gameRequest(gid))
+		if resp["kind"] != c.kind || fmt.Sprint(resp["cells"]) != c.want {
+			t.Errorf("after %v: %v %v, want %s %s", c.cells, resp["kind"], resp["cells"], c.kind, c.want)
+		}
+	}
+}
+
+func TestWinningCellsIgnoreTheTurn(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4, 8)
+
+	players := mustRPC(t, getWinningCellsRPC, gameRequest(gid))["players"].(map[string]interface{})
+	x, o := players["X"].(map[string]interface{}), players["O"].(map[string]interface{})
+	if x["can_win"] != true || fmt.Sprint(x["cells"]) != "[2]" || x["cell"] != 2.0 {
+		t.Fatalf("X = %v", x)
+	}
+	if o["can_win"] != true || fmt.Sprint(o["cells"]) != "[5]" {
+		t.Fatalf("O = %v", o)
+	}
+}
+
+func TestMirrorBot(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"vs_bot":true,"bot_difficulty":"mirror"}`)
+
+	playCells(t, gid, 0)
+	if board := gameState(t, gid).Board; board != "X-------O" {
+		t.Fatalf("bot didn't take the opposite corner: %s", board)
+	}
+	// the center mirrors onto itself, so the bot falls back to the first open cell
+	playCells(t, gid, 4)
+	if board := gameState(t, gid).Board; board != "XO--X---O" {
+		t.Fatalf("bot didn't take the first open cell: %s", board)
+	}
+
+	if _, err := callRPC(t, createGameRPC, `{"vs_bot":true,"bot_difficulty":"easy"}`); err == nil || err.Error() != `bot_difficulty must be "best" or "mirror"` {
+		t.Fatalf("unknown difficulty: err = %v", err)
+	}
+}
+
+func TestGetGameAnalysisSearchesEachPositionOnce(t *testing.T) {
+	setupTest(t)
+	// the only line is the whole board, which two players can never fill alone: a dead draw
+	// with the largest possible game tree
+	gid := createGame(t, `{"win_lines":[[0,1,2,3,4,5,6,7,8]]}`)
+
+	memo := map[string]bool{}
+	game := gameState(t, gid)
+	if canStillWin(game, game.Board, game.Turn, memo) {
+		t.Fatal("a win was found on an unwinnable board")
+	}
+	// every position short of a full board is searched, once: sum of C(9,k)*C(k,k/2) for k < 9
+	if len(memo) != 5920 {
+		t.Fatalf("%d positions memoized, want 5920", len(memo))
+	}
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		resp := mustRPC(t, getGameRPC, gameRequest(gid))
+		if resp["auto_draw_available"] != true {
+			t.Fatalf("resp = %v", resp)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("20 polls took %v", elapsed)
+	}
+}
+
+func TestIncludeEvalOnALargeTree(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"win_lines":[[0,1,2,3,4,5,6,7,8]]}`)
+	playCells(t, gid, 4)
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		eval := mustRPC(t, getGameRPC, gameRequest(gid, `"include_eval":true`))["eval"].(map[string]interface{})
+		if eval["X"] != 0.0 || eval["O"] != 0.0 {
+			t.Fatalf("eval = %v, want a draw", eval)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("20 polls took %v", elapsed)
+	}
+}
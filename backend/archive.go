@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"time"
+)
+
+// With TTT_ARCHIVE_GAMES on, finished games move out of memory into Nakama storage
+// archiveDelayMs after they end. The read-only RPCs for finished games (get_game,
+// get_game_summary, export_notation and get_last_move) fall back to the archive for them,
+// and get_statistics keeps counting them from archivedTotals.
+
+// storage collection holding archived games under the system user, keyed by game id
+const archiveCollection = "archive"
+
+// archivedGame is the stored value, the game plus the timeline its JSON normally leaves out
+type archivedGame struct {
+	*Game
+	Events []Event `json:"events"`
+}
+
+// archivedTotals adds up the games archived since the server started. Guarded by gamesMu.
+var archivedTotals = newGameTotals()
+
+// registerArchive: hook archiving into game end, called from InitModule once config is loaded
+func registerArchive(logger runtime.Logger, nk runtime.NakamaModule) {
+	if !archiveGames {
+		return
+	}
+	RegisterOnGameEnd(func(game *Game) {
+		gid := game.ID
+		time.AfterFunc(time.Duration(archiveDelayMs)*time.Millisecond, func() {
+			archiveGame(context.Background(), logger, nk, gid)
+		})
+	})
+}
+
+// archiveGame: write a finished game to storage and drop it from the games map.
+// A game that was reopened since it finished stays live; if it finishes again its own
+// hook archives the final state. One that changed while it was being written, say a new
+// spectator, is written again.
+func archiveGame(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, gid string) {
+	for {
+		game, err := lockGame(gid)
+		if err != nil {
+			return // already gone, e.g. evicted
+		}
+		if game.Winner == "" {
+			game.mu.Unlock()
+			return
+		}
+		version := game.Version
+		value, _ := json.Marshal(archivedGame{Game: game, Events: game.Events})
+		game.mu.Unlock()
+
+		write := &runtime.StorageWrite{
+			Collection:      archiveCollection,
+			Key:             gid,
+			Value:           string(value),
+			PermissionRead:  0, // only the server reads the archive
+			PermissionWrite: 0,
+		}
+		if _, err := nk.StorageWrite(ctx, []*runtime.StorageWrite{write}); err != nil {
+			logger.WithField("game_id", gid).Error("Unable to archive game: %v", err)
+			return
+		}
+
+		gamesMu.Lock()
+		game.mu.Lock()
+		if !game.removed && game.Version == version {
+			game.removed = true
+			delete(games, game.ID)
+			archivedTotals.add(game)
+		}
+		archived := game.removed
+		game.mu.Unlock()
+		gamesMu.Unlock()
+		if archived {
+			logDebug(logger, map[string]interface{}{"game_id": gid}, "game archived")
+			return
+		}
+	}
+}
+
+// loadArchivedGame: read a game back from the archive. It's returned locked like lockGame's
+// games so callers can treat both alike, but it isn't in the games map and changes aren't saved.
+func loadArchivedGame(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, gid string) (*Game, error) {
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{{Collection: archiveCollection, Key: normalizeGameID(gid)}})
+	if err != nil {
+		logger.WithField("game_id", gid).Error("Unable to read archived game: %v", err)
+		return nil, errInternal
+	}
+	if len(objects) == 0 {
+		return nil, errGameNotFound
+	}
+	stored := archivedGame{Game: &Game{}}
+	if err := json.Unmarshal([]byte(objects[0].Value), &stored); err != nil {
+		logger.WithField("game_id", gid).Error("Archived game is corrupt: %v", err)
+		return nil, errInternal
+	}
+	game := stored.Game
+	game.Events = stored.Events
+	game.mu.Lock()
+	return game, nil
+}
+
+// lockGameOrArchive: lockGame, falling back to the archive when archiving is on.
+// Only for RPCs that read the game, changes to an archived game aren't saved.
+func lockGameOrArchive(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, gid string) (*Game, error) {
+	game, err := lockGame(gid)
+	if errors.Is(err, errGameNotFound) && archiveGames {
+		return loadArchivedGame(ctx, logger, nk, gid)
+	}
+	return game, err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"testing"
+	"time"
+)
+
+// archivedGameID: a game X won on the top row, already moved to nk's archive
+func archivedGameID(t *testing.T, nk *fakeNK) string {
+	t.Helper()
+	setValue(t, &archiveGames, true)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4, 2)
+	archiveGame(context.Background(), newTestLogger(), nk, gid)
+	if _, err := lockGame(gid); err != errGameNotFound {
+		t.Fatalf("game still in memory after archiving: %v", err)
+	}
+	return gid
+}
+
+func TestArchivedGameReads(t *testing.T) {
+	setupTest(t)
+	nk := &fakeNK{}
+	gid := archivedGameID(t, nk)
+	ctx := context.Background()
+
+	cases := []struct {
+		name  string
+		fn    rpcFunc
+		check func(map[string]interface{}) bool
+	}{
+		{"get_game", getGameRPC, func(r map[string]interface{}) bool {
+			return r["game"].(map[string]interface{})["winner"] == "X"
+		}},
+		{"get_game_summary", getGameSummaryRPC, func(r map[string]interface{}) bool {
+			return r["summary"].(map[string]interface{})["total_moves"] == 5.0
+		}},
+		{"export_notation", exportNotationRPC, func(r map[string]interface{}) bool {
+			return r["notation"] == "0,3,1,4,2"
+		}},
+		{"get_last_move", getLastMoveRPC, func(r map[string]interface{}) bool {
+			return r["move"].(map[string]interface{})["cell"] == 2.0 && r["status"] == "finished"
+		}},
+	}
+	for _, c := range cases {
+		resp, err := callRPCWith(t, ctx, nk, c.fn, gameRequest(gid))
+		if err != nil || !c.check(resp) {
+			t.Errorf("%s: %v, %v", c.name, resp, err)
+		}
+	}
+
+	if _, err := callRPCWith(t, ctx, nk, makeMoveRPC, gameRequest(gid, `"cell":8`)); err != errGameNotFound {
+		t.Fatalf("make_move on an archived game: err = %v", err)
+	}
+}
+
+func TestStatisticsCountArchivedGames(t *testing.T) {
+	setupTest(t)
+	archivedGameID(t, &fakeNK{})
+	playCells(t, createGame(t, `{}`), drawnGame...)
+
+	resp := mustRPC(t, getStatisticsRPC, `{}`)
+	if resp["games_played"] != 2.0 || resp["average_moves"] != 7.0 || resp["draw_rate"] != 0.5 {
+		t.Fatalf("statistics = %v", resp)
+	}
+	if points := resp["points"].(map[string]interface{}); points["X"] != 1.0 {
+		t.Fatalf("points = %v", points)
+	}
+}
+
+func TestArchiveSkipsReopenedGames(t *testing.T) {
+	setupTest(t)
+	setValue(t, &archiveGames, true)
+	nk := &fakeNK{}
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4, 2)
+	mustRPC(t, adminSetCellRPC, gameRequest(gid, `"cell":2`))
+
+	archiveGame(context.Background(), newTestLogger(), nk, gid)
+	if game := gameState(t, gid); game.Winner != "" {
+		t.Fatalf("reopened game: winner %q", game.Winner)
+	}
+	if len(nk.storage) != 0 {
+		t.Fatalf("reopened game was archived: %v", nk.storage)
+	}
+}
+
+// joiningNK is a fakeNK where someone starts spectating the game during its first archive write
+type joiningNK struct {
+	*fakeNK
+	t      *testing.T
+	gid    string
+	joined bool
+}
+
+func (nk *joiningNK) StorageWrite(ctx context.Context, writes []*runtime.StorageWrite) ([]*api.StorageObjectAck, error) {
+	if !nk.joined {
+		nk.joined = true
+		callRPCWith(nk.t, userContext("late", "s1"), nk.fakeNK, spectateGameRPC, gameRequest(nk.gid))
+	}
+	return nk.fakeNK.StorageWrite(ctx, writes)
+}
+
+func TestArchiveRewritesGamesChangedDuringTheWrite(t *testing.T) {
+	setupTest(t)
+	setValue(t, &archiveGames, true)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4, 2)
+	nk := &joiningNK{fakeNK: &fakeNK{}, t: t, gid: gid}
+
+	archiveGame(context.Background(), newTestLogger(), nk, gid)
+	if _, err := lockGame(gid); err != errGameNotFound {
+		t.Fatalf("game still in memory: %v", err)
+	}
+	resp, err := callRPCWith(t, context.Background(), nk, getGameRPC, gameRequest(gid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spectators := resp["game"].(map[string]interface{})["spectators"].([]interface{}); len(spectators) != 1 {
+		t.Fatalf("archived game = %v, want the late spectator in it", resp)
+	}
+}
+
+func TestArchiveTimerMovesFinishedGames(t *testing.T) {
+	setupTest(t)
+	setValue(t, &archiveGames, true)
+	setValue(t, &archiveDelayMs, int64(20))
+	setValue(t, &gameEndHooks, nil)
+	nk := &fakeNK{}
+	registerArchive(newTestLogger(), nk)
+
+	finished, reopened := createGame(t, `{}`), createGame(t, `{}`)
+	playCells(t, finished, 0, 3, 1, 4, 2)
+	playCells(t, reopened, 0, 3, 1, 4, 2)
+	// an admin reopens this one before its timer fires, so it stays live
+	mustRPC(t, adminSetCellRPC, gameRequest(reopened, `"cell":2`))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		game, err := lockGame(finished)
+		if err == errGameNotFound {
+			break
+		}
+		game.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatal("finished game wasn't archived")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	nk.mu.Lock()
+	_, stored := nk.storage[storageKey(archiveCollection, "", finished)]
+	_, reopenedStored := nk.storage[storageKey(archiveCollection, "", reopened)]
+	nk.mu.Unlock()
+	if !stored || reopenedStored {
+		t.Fatalf("archived finished: %v, reopened: %v", stored, reopenedStored)
+	}
+	if game := gameState(t, reopened); game.Winner != "" {
+		t.Fatalf("reopened game: winner %q", game.Winner)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"time"
+)
+
+// Blitz clock: each player in a clocked game has a total time budget that only runs
+// down on their own turn. Running out loses the game once clockGraceMs has also passed;
+// a move made in the grace window still counts. With ack_moves a turn's clock
+// only starts once the player to move has acknowledged the previous move.
+
+// nowMs is the clock used for all game timing, swappable so timing can be simulated
+var nowMs = func() int64 {
+	return time.Now().UnixMilli()
+}
+
+// startClock: give every player the full budget and start the first turn. Caller must hold game.mu.
+func startClock(game *Game, seconds int) {
+	if seconds <= 0 {
+		return
+	}
+	game.ClockMs = int64(seconds) * 1000
+	game.RemainingMs = map[string]int64{}
+	for _, m := range game.Marks {
+		game.RemainingMs[m] = game.ClockMs
+	}
+	game.TurnStartedAt = nowMs()
+}
+
+// helper: budget left for mark right now counting the running turn, negative once it's overdrawn
+func clockLeft(game *Game, mark string) int64 {
+	left := game.RemainingMs[mark]
+	if mark == game.Turn && game.Winner == "" && !game.AwaitingAck {
+		left -= nowMs() - game.TurnStartedAt
+	}
+	return left
+}
+
+// helper: budget left for mark right now, counting the running turn
+func remainingFor(game *Game, mark string) int64 {
+	if left := clockLeft(game, mark); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// helper: whether the player to move has run out of budget but is still within the grace period
+func inGrace(game *Game) bool {
+	return game.ClockMs > 0 && game.Winner == "" && clockLeft(game, game.Turn) <= 0
+}
+
+// flagFall: end the game if the player to move has used up their budget, reports
+// whether that happened. Caller must hold game.mu.
+func flagFall(game *Game) bool {
+	if game.ClockMs == 0 || game.Winner != "" || clockLeft(game, game.Turn)+clockGraceMs > 0 {
+		return false
+	}
+	game.RemainingMs[game.Turn] = 0
+	addEvent(game, Event{Type: "timeout", Mark: game.Turn})
+	game.Winner = otherMark(game, game.Turn)
+	game.WinKind = "timeout"
+	game.Points = winPoints["timeout"]
+	game.Version++
+	addFinishEvent(game)
+	return true
+}
+
+// checkFlag: flagFall for RPCs that only read the game, pushing a game it ends to spectators.
+// Caller must hold game.mu.
+func checkFlag(logger runtime.Logger, nk runtime.NakamaModule, game *Game) {
+	if flagFall(game) {
+		broadcastGame(logger, nk, game)
+	}
+}
+
+// chargeClock: take the time the mover spent off their budget and start the next turn.
+// Caller must hold game.mu and call it after the move, with the mover's mark.
+func chargeClock(game *Game, mover string) {
+	if game.ClockMs == 0 {
+		return
+	}
+	now := nowMs()
+	game.RemainingMs[mover] -= now - game.TurnStartedAt
+	if game.RemainingMs[mover] < 0 {
+		// moved during the grace period
+		game.RemainingMs[mover] = 0
+	}
+	game.TurnStartedAt = now
+	game.AwaitingAck = game.AckMoves && game.Winner == ""
+}
+
+// helper: live clock state for responses, nil for games without a clock
+func clockState(game *Game) map[string]interface{} {
+	if game.ClockMs == 0 {
+		return nil
+	}
+	remaining := map[string]int64{}
+	for _, m := range game.Marks {
+		remaining[m] = remainingFor(game, m)
+	}
+	state := map[string]interface{}{
+		"remaining_ms": remaining,
+	}
+	if game.AwaitingAck {
+		state["awaiting_ack"] = true
+	} else if game.Winner == "" {
+		// absolute Unix ms at which the player to move runs out, compare with get_server_time
+		state["turn_deadline"] = game.TurnStartedAt + game.RemainingMs[game.Turn]
+		if inGrace(game) {
+			// past the deadline, the game is lost on time at grace_deadline
+			state["in_grace"] = true
+			state["grace_deadline"] = game.TurnStartedAt + game.RemainingMs[game.Turn] + clockGraceMs
+		}
+	}
+	return state
+}
+
+// ackMoveRPC: the player to move acknowledges the last move, which starts their clock.
+// Expects {"game_id":"...","mark":"O"}, only for games created with ack_moves.
+func ackMoveRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req AckMoveRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	if !containsString(game.Marks, req.Mark) {
+		return "", errors.New("invalid mark")
+	}
+	if !game.AwaitingAck {
+		return "", errors.New("no move to acknowledge")
+	}
+	if req.Mark != game.Turn {
+		return "", errors.New("not your turn")
+	}
+	if err := claimSeat(ctx, game, req.Mark); err != nil {
+		return "", err
+	}
+
+	game.AwaitingAck = false
+	game.TurnStartedAt = nowMs()
+	game.Version++
+	addEvent(game, Event{Type: "ack", Actor: callerID(ctx), Mark: req.Mark})
+	broadcastGame(logger, nk, game)
+
+	resp := map[string]interface{}{
+		"ok":      true,
+		"version": game.Version,
+		"clock":   clockState(game),
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
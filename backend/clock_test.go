@@ -0,0 +1,115 @@
+package main
+
+import "testing"
+
+// setClock: pin nowMs for the rest of the test and return a function that moves it on
+func setClock(t *testing.T, start int64) func(ms int64) {
+	now := start
+	setValue(t, &nowMs, func() int64 { return now })
+	return func(ms int64) { now += ms }
+}
+
+func TestClockRunsOutOnTime(t *testing.T) {
+	setupTest(t)
+	advance := setClock(t, 1000)
+	gid := createGame(t, `{"clock_seconds":5}`)
+
+	advance(2000)
+	playCells(t, gid, 4)
+	advance(6000)
+
+	game := mustRPC(t, getGameRPC, gameRequest(gid))["game"].(map[string]interface{})
+	if game["winner"] != "X" || game["win_kind"] != "timeout" {
+		t.Fatalf("winner %v by %v, want X on time", game["winner"], game["win_kind"])
+	}
+	if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":0`)); err == nil {
+		t.Fatal("O moved after running out of time")
+	}
+}
+
+func TestClockChargesOnlyTheMover(t *testing.T) {
+	setupTest(t)
+	advance := setClock(t, 1000)
+	gid := createGame(t, `{"clock_seconds":5}`)
+
+	advance(1500)
+	playCells(t, gid, 4)
+	advance(700)
+
+	clock := mustRPC(t, getGameRPC, gameRequest(gid))["clock"].(map[string]interface{})
+	remaining := clock["remaining_ms"].(map[string]interface{})
+	if remaining["X"] != 3500.0 || remaining["O"] != 4300.0 || clock["turn_deadline"] != 7500.0 {
+		t.Fatalf("clock = %v", clock)
+	}
+}
+
+func TestRankedRejectsMovesTooFast(t *testing.T) {
+	setupTest(t)
+	setValue(t, &minThinkMs, int64(500))
+	advance := setClock(t, 1000)
+	gid := createGame(t, `{"ranked":true}`)
+	ctx := userContext("u1", "s1")
+
+	if _, err := callRPCWith(t, ctx, nil, makeMoveRPC, gameRequest(gid, `"cell":4`)); err != nil {
+		t.Fatalf("opening move: %v", err)
+	}
+	advance(200)
+	if _, err := callRPCWith(t, ctx, nil, makeMoveRPC, gameRequest(gid, `"cell":0`)); err != errMoveTooFast {
+		t.Fatalf("reply after 200ms: err = %v, want move too fast", err)
+	}
+	advance(300)
+	if _, err := callRPCWith(t, ctx, nil, makeMoveRPC, gameRequest(gid, `"cell":0`)); err != nil {
+		t.Fatalf("reply after 500ms: %v", err)
+	}
+}
+
+func TestAckMoveStartsTheClock(t *testing.T) {
+	setupTest(t)
+	advance := setClock(t, 1000)
+	gid := createGame(t, `{"clock_seconds":5,"ack_moves":true}`)
+	playCells(t, gid, 4)
+
+	// O's clock doesn't run until O has seen the move
+	advance(3000)
+	clock := mustRPC(t, getGameRPC, gameRequest(gid))["clock"].(map[string]interface{})
+	if clock["awaiting_ack"] != true || clock["remaining_ms"].(map[string]interface{})["O"] != 5000.0 {
+		t.Fatalf("clock before ack = %v", clock)
+	}
+	if _, err := callRPC(t, ackMoveRPC, gameRequest(gid, `"mark":"X"`)); err == nil || err.Error() != "not your turn" {
+		t.Fatalf("X acking O's turn: err = %v", err)
+	}
+	mustRPC(t, ackMoveRPC, gameRequest(gid, `"mark":"O"`))
+	if _, err := callRPC(t, ackMoveRPC, gameRequest(gid, `"mark":"O"`)); err == nil || err.Error() != "no move to acknowledge" {
+		t.Fatalf("second ack: err = %v", err)
+	}
+
+	advance(1000)
+	clock = mustRPC(t, getGameRPC, gameRequest(gid))["clock"].(map[string]interface{})
+	if clock["remaining_ms"].(map[string]interface{})["O"] != 4000.0 || clock["turn_deadline"] != 9000.0 {
+		t.Fatalf("clock after ack = %v", clock)
+	}
+}
+
+func TestGracePeriodBeforeLosingOnTime(t *testing.T) {
+	setupTest(t)
+	setValue(t, &clockGraceMs, int64(1000))
+	advance := setClock(t, 1000)
+	gid := createGame(t, `{"clock_seconds":5}`)
+
+	// out of time but in grace: still playable, and the move still counts
+	advance(5500)
+	clock := mustRPC(t, getGameRPC, gameRequest(gid))["clock"].(map[string]interface{})
+	if clock["in_grace"] != true || clock["grace_deadline"] != 7000.0 {
+		t.Fatalf("clock = %v", clock)
+	}
+	playCells(t, gid, 4)
+	if game := gameState(t, gid); game.Winner != "" || game.RemainingMs["X"] != 0 {
+		t.Fatalf("winner %q, X remaining %d after a move in grace", game.Winner, game.RemainingMs["X"])
+	}
+
+	// O runs past the budget and the grace period too
+	advance(6000)
+	if game := mustRPC(t, getGameRPC, gameRequest(gid))["game"].(map[string]interface{}); game["winner"] != "X" {
+		t.Fatalf("winner = %v, want X on time", game["winner"])
+	}
+}
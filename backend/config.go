@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// debugEnabled gates the module's own debug logs, set from TTT_LOG_LEVEL in InitModule
+var debugEnabled = false
+
+// minThinkMs is the shortest gap allowed between moves in ranked games, 0 turns the check off.
+// Set from TTT_MIN_THINK_MS.
+var minThinkMs int64 = 0
+
+// maxIllegalMoves forfeits a player after this many illegal moves in a row, 0 turns it off.
+// Set from TTT_MAX_ILLEGAL_MOVES.
+var maxIllegalMoves = 0
+
+// clockGraceMs is extra time past a clock running out before the player loses on time,
+// so a network hiccup doesn't cost the game. Set from TTT_CLOCK_GRACE_MS.
+var clockGraceMs int64 = 0
+
+// maxSpectators caps how many users can spectate one game, 0 means no cap. Set from TTT_MAX_SPECTATORS.
+var maxSpectators = 0
+
+// maxSpectatePerUser caps how many games one user can spectate at once, 0 means no cap.
+// Set from TTT_MAX_SPECTATE_PER_USER.
+var maxSpectatePerUser = 0
+
+// aiTieBreak orders moves with equal minimax scores: "lowest_index" or "natural" (center, then
+// corners, then edges). Set from TTT_AI_TIE_BREAK.
+var aiTieBreak = "lowest_index"
+
+// checkInvariants verifies every board transition in applyMove, for debug and test runs.
+// Set from TTT_CHECK_INVARIANTS=true.
+var checkInvariants = false
+
+// archiveGames moves finished games from memory to the archive storage collection.
+// Set from TTT_ARCHIVE_GAMES=true.
+var archiveGames = false
+
+// archiveDelayMs is how long a finished game stays in memory before it's archived, so
+// clients still polling it see the result first. Set from TTT_ARCHIVE_DELAY_MS.
+var archiveDelayMs int64 = 30000
+
+// errorsAsData makes every RPC return errors inside an {"ok":false} payload instead of failing.
+// Set from TTT_ERRORS_AS_DATA=true.
+var errorsAsData = false
+
+// maxGames caps how many games are kept in memory, 0 means no cap. Set from TTT_MAX_GAMES.
+var maxGames = 0
+
+// helper: read a setting from the Nakama runtime env, falling back to the process env
+func getEnv(ctx context.Context, key string) string {
+	if env, ok := ctx.Value(runtime.RUNTIME_CTX_ENV).(map[string]string); ok {
+		if v, ok := env[key]; ok {
+			return v
+		}
+	}
+	return os.Getenv(key)
+}
+
+// loadConfig: read module settings, called once from InitModule
+func loadConfig(ctx context.Context, logger runtime.Logger) {
+	switch level := strings.ToLower(getEnv(ctx, "TTT_LOG_LEVEL")); level {
+	case "debug":
+		debugEnabled = true
+	case "", "info":
+		debugEnabled = false
+	default:
+		logger.Warn("Unknown TTT_LOG_LEVEL %q, defaulting to info", level)
+		debugEnabled = false
+	}
+
+	adminIDs = parseAdminIDs(getEnv(ctx, "TTT_ADMIN_USER_IDS"))
+	maxGames = getEnvInt(ctx, logger, "TTT_MAX_GAMES", 0)
+	minThinkMs = int64(getEnvInt(ctx, logger, "TTT_MIN_THINK_MS", 0))
+	maxIllegalMoves = getEnvInt(ctx, logger, "TTT_MAX_ILLEGAL_MOVES", 0)
+	clockGraceMs = int64(getEnvInt(ctx, logger, "TTT_CLOCK_GRACE_MS", 0))
+	maxSpectators = getEnvInt(ctx, logger, "TTT_MAX_SPECTATORS", 0)
+	maxSpectatePerUser = getEnvInt(ctx, logger, "TTT_MAX_SPECTATE_PER_USER", 0)
+	checkInvariants = strings.EqualFold(getEnv(ctx, "TTT_CHECK_INVARIANTS"), "true")
+	errorsAsData = strings.EqualFold(getEnv(ctx, "TTT_ERRORS_AS_DATA"), "true")
+	archiveGames = strings.EqualFold(getEnv(ctx, "TTT_ARCHIVE_GAMES"), "true")
+	archiveDelayMs = int64(getEnvInt(ctx, logger, "TTT_ARCHIVE_DELAY_MS", 30000))
+
+	switch tieBreak := strings.ToLower(getEnv(ctx, "TTT_AI_TIE_BREAK")); tieBreak {
+	case "", "lowest_index":
+		aiTieBreak = "lowest_index"
+	case "natural":
+		aiTieBreak = "natural"
+	default:
+		logger.Warn("Unknown TTT_AI_TIE_BREAK %q, defaulting to lowest_index", tieBreak)
+		aiTieBreak = "lowest_index"
+	}
+}
+
+// helper: read a non-negative integer setting, warning and using def when it's invalid
+func getEnvInt(ctx context.Context, logger runtime.Logger, key string, def int) int {
+	v := getEnv(ctx, key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		logger.Warn("Invalid %s %q, defaulting to %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// logMoveAnalytics: one structured log line per applied move for analytics pipelines to ingest,
+// always on unlike logDebug. Caller must hold game.mu and call it right after the move.
+func logMoveAnalytics(logger runtime.Logger, game *Game) {
+	move := game.History[len(game.History)-1]
+	logger.WithFields(map[string]interface{}{
+		"event":      "move",
+		"game_id":    game.ID,
+		"move_index": len(game.History) - 1,
+		"cell":       move.Cell,
+		"mark":       move.Mark,
+		"players":    game.Marks,
+		"status":     gameStatus(game),
+		"winner":     game.Winner,
+	}).Info("analytics")
+}
+
+// logDebug: structured debug log, suppressed unless TTT_LOG_LEVEL=debug
+func logDebug(logger runtime.Logger, fields map[string]interface{}, format string, v ...interface{}) {
+	if !debugEnabled {
+		return
+	}
+	logger.WithFields(fields).Debug(format, v...)
+}
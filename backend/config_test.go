@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"testing"
+)
+
+// helper: ctx carrying a Nakama runtime env
+func envContext(env map[string]string) context.Context {
+	return context.WithValue(context.Background(), runtime.RUNTIME_CTX_ENV, env)
+}
+
+func TestLogLevelFromEnv(t *testing.T) {
+	setValue(t, &debugEnabled, false)
+	for level, want := range map[string]bool{"debug": true, "DEBUG": true, "info": false, "": false, "loud": false} {
+		loadConfig(envContext(map[string]string{"TTT_LOG_LEVEL": level}), newTestLogger())
+		if debugEnabled != want {
+			t.Errorf("TTT_LOG_LEVEL=%q: debug = %v, want %v", level, debugEnabled, want)
+		}
+	}
+}
+
+func TestMoveDebugLogOnlyWhenEnabled(t *testing.T) {
+	setupTest(t)
+	for _, enabled := range []bool{false, true} {
+		setValue(t, &debugEnabled, enabled)
+		gid := createGame(t, `{}`)
+		logger := newTestLogger()
+		if _, err := makeMoveRPC(context.Background(), logger, nil, nil, gameRequest(gid, `"cell":4`)); err != nil {
+			t.Fatal(err)
+		}
+		if lines := logger.find("debug", "move applied"); (len(lines) == 1) != enabled {
+			t.Errorf("debug %v: %d move applied lines", enabled, len(lines))
+		}
+	}
+}
+
+func TestAnalyticsLineForEveryMove(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4)
+	logger := newTestLogger()
+
+	if _, err := makeMoveRPC(context.Background(), logger, nil, nil, gameRequest(gid, `"cell":2`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := playMovesRPC(context.Background(), logger, nil, nil, gameRequest(createGame(t, `{}`), `"cells":[4,0]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := logger.find("info", "analytics")
+	if len(lines) != 3 {
+		t.Fatalf("%d analytics lines, want 3", len(lines))
+	}
+	win := lines[0].fields
+	if win["event"] != "move" || win["game_id"] != gid || win["move_index"] != 4 || win["cell"] != 2 ||
+		win["mark"] != "X" || win["status"] != "finished" || win["winner"] != "X" {
+		t.Fatalf("winning move fields = %v", win)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/heroiclabs/nakama-common/runtime"
+	"hash/fnv"
 	"math/rand"
 	"strconv"
 	"strings"
@@ -16,142 +18,981 @@ import (
 
 // Game struct (in-memory)
 type Game struct {
-	ID     string `json:"game_id"`
-	Board  string `json:"board"`  // 9-char string: "-" for empty, "X" or "O"
-	Turn   string `json:"turn"`   // "X" or "O"
-	Winner string `json:"winner"` // "", "X", "O", "draw"
+	ID     string   `json:"game_id"`
+	Board  string   `json:"board"`   // 9-char string: "-" for empty, otherwise a player mark
+	Turn   string   `json:"turn"`    // mark of the player to move, see Marks
+	Winner string   `json:"winner"`  // "", a player mark or "draw"
+	NoDraw bool     `json:"no_draw"` // full board is decided by tieBreakWinner instead of "draw"
+	Marks  []string `json:"marks"`   // marks in turn order, one per player
+
+	IllegalMoves map[string]int `json:"illegal_moves,omitempty"` // consecutive illegal attempts per mark
+	DrawAccepts  []string       `json:"draw_accepts,omitempty"`  // marks that accepted the auto-draw offer
+
+	RequireConfirm bool `json:"require_confirm"` // game-ending moves must be sent with "confirm":true
+	Ranked         bool `json:"ranked"`          // anti-cheat checks such as minThinkMs apply
+
+	ClockMs       int64            `json:"clock_ms,omitempty"`        // per-player time budget, 0 for no clock
+	RemainingMs   map[string]int64 `json:"remaining_ms,omitempty"`    // budget left per mark as of TurnStartedAt
+	TurnStartedAt int64            `json:"turn_started_at,omitempty"` // Unix ms when the current turn began
+	AckMoves      bool             `json:"ack_moves,omitempty"`       // a turn's clock only starts once ack_move is called
+	AwaitingAck   bool             `json:"awaiting_ack,omitempty"`    // the player to move hasn't acknowledged the last move yet
+
+	WinLine  []int   `json:"win_line,omitempty"`  // cells of the winning line
+	WinLines [][]int `json:"win_lines,omitempty"` // custom lines set at creation, nil for the standard ones
+	WinKind  string  `json:"win_kind,omitempty"`  // "row", "column", "diagonal", "custom", "tie_break", "timeout", "forfeit" or "auto_draw"
+	Points   int     `json:"points"`              // points earned by the winner, see winPoints
+
+	BotMark     string `json:"bot_mark,omitempty"`     // mark played by the practice bot, "" when there's none
+	BotLevel    string `json:"bot_level,omitempty"`    // how the bot picks its moves: "best" or "mirror"
+	MaxDistance int    `json:"max_distance,omitempty"` // moves after the first must be this close to a mark, 0 for no limit
+
+	BindSessions bool `json:"bind_sessions,omitempty"` // each mark can only be played from the first session that moved it
+
+	First string `json:"first"`          // mark that made or makes the first move
+	Seed  *int64 `json:"seed,omitempty"` // seed the starter was drawn from for first:"random"
+
+	Version int          `json:"version"` // bumped on every state change
+	History []Move       `json:"history"` // applied moves, oldest first
+	Audit   []AuditEntry `json:"audit"`   // admin corrections, oldest first
+
+	Spectators []string `json:"spectators"` // user ids watching through the game's stream
+
+	PlayerMeta map[string]PlayerMeta `json:"player_meta,omitempty"` // display metadata by mark
+
+	Events []Event `json:"-"` // timeline of everything that happened, only sent when asked for
+
+	mu         sync.Mutex // guards all fields above once the game is in the games map
+	removed    bool       // set under mu when the game is deleted from the map
+	lastAccess int64      // UnixNano of the last lookup, for LRU eviction
+
+	seatSessions map[string]string // session id bound to each mark under BindSessions, never sent to clients
+
+	spectatorSessions map[string]map[string]bool // sessions on the stream for each user in Spectators
+
+	replaying bool // set while import_notation or seed_games replays moves into a game that isn't stored
+	// yet: live play timing checks, moves_served and the game-end hooks are skipped. An imported game
+	// runs the hooks once it's stored
+}
+
+// Clone: deep copy of the game's rules and state that analysis code can change freely.
+// The copy isn't in the games map and has its own mutex. Caller must hold game.mu.
+func (game *Game) Clone() *Game {
+	c := &Game{
+		ID:             game.ID,
+		Board:          game.Board,
+		Turn:           game.Turn,
+		Winner:         game.Winner,
+		NoDraw:         game.NoDraw,
+		Marks:          append(make([]string, 0, len(game.Marks)), game.Marks...),
+		RequireConfirm: game.RequireConfirm,
+		Ranked:         game.Ranked,
+		ClockMs:        game.ClockMs,
+		TurnStartedAt:  game.TurnStartedAt,
+		AckMoves:       game.AckMoves,
+		AwaitingAck:    game.AwaitingAck,
+		WinLine:        append([]int(nil), game.WinLine...),
+		WinLines:       game.WinLines, // never changed after creation
+		First:          game.First,
+		BotMark:        game.BotMark,
+		BotLevel:       game.BotLevel,
+		MaxDistance:    game.MaxDistance,
+		BindSessions:   game.BindSessions,
+		Seed:           game.Seed,
+		WinKind:        game.WinKind,
+		Points:         game.Points,
+		Version:        game.Version,
+		History:        append(make([]Move, 0, len(game.History)), game.History...),
+		Audit:          append(make([]AuditEntry, 0, len(game.Audit)), game.Audit...),
+		Spectators:     append(make([]string, 0, len(game.Spectators)), game.Spectators...),
+		DrawAccepts:    append([]string(nil), game.DrawAccepts...),
+		Events:         append([]Event(nil), game.Events...),
+	}
+	if game.seatSessions != nil {
+		c.seatSessions = map[string]string{}
+		for k, v := range game.seatSessions {
+			c.seatSessions[k] = v
+		}
+	}
+	if game.spectatorSessions != nil {
+		c.spectatorSessions = map[string]map[string]bool{}
+		for user, sessions := range game.spectatorSessions {
+			c.spectatorSessions[user] = map[string]bool{}
+			for s := range sessions {
+				c.spectatorSessions[user][s] = true
+			}
+		}
+	}
+	if game.IllegalMoves != nil {
+		c.IllegalMoves = map[string]int{}
+		for k, v := range game.IllegalMoves {
+			c.IllegalMoves[k] = v
+		}
+	}
+	if game.RemainingMs != nil {
+		c.RemainingMs = map[string]int64{}
+		for k, v := range game.RemainingMs {
+			c.RemainingMs[k] = v
+		}
+	}
+	if game.PlayerMeta != nil {
+		c.PlayerMeta = map[string]PlayerMeta{}
+		for k, v := range game.PlayerMeta {
+			c.PlayerMeta[k] = v
+		}
+	}
+	return c
+}
+
+// Move is one applied move
+type Move struct {
+	Cell    int    `json:"cell"`
+	Name    string `json:"name"` // human name of the cell, see cellName
+	Mark    string `json:"mark"`
+	Version int    `json:"version"`         // game version after the move
+	At      int64  `json:"at"`              // Unix ms when the move was applied
+	Actor   string `json:"actor,omitempty"` // user id that made the move, when known
+}
+
+// Event is one entry in a game's timeline
+type Event struct {
+	Type    string `json:"type"`            // "created", "move", "ack", "win", "draw", "timeout", "forfeit", "auto_draw" or "set_cell"
+	At      int64  `json:"at"`              // Unix ms
+	Actor   string `json:"actor,omitempty"` // user id of the caller, when known
+	Cell    *int   `json:"cell,omitempty"`
+	Mark    string `json:"mark,omitempty"` // the mover, the winner, or the player who timed out or forfeited
+	Version int    `json:"version"`        // game version after the event
+}
+
+// addEvent: append to the game's timeline at the current time and version. Caller must hold game.mu.
+func addEvent(game *Game, e Event) {
+	e.At = nowMs()
+	e.Version = game.Version
+	game.Events = append(game.Events, e)
+}
+
+// helper: timeline entry for how a just-finished game ended, then the game-end hooks
+func addFinishEvent(game *Game) {
+	if game.Winner == "draw" {
+		addEvent(game, Event{Type: "draw"})
+	} else {
+		addEvent(game, Event{Type: "win", Mark: game.Winner})
+	}
+	if !game.replaying {
+		runGameEndHooks(game)
+	}
+}
+
+// helper: user id of the caller, "" for server-to-server calls
+func callerID(ctx context.Context) string {
+	userID, _ := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	return userID
+}
+
+// helper: under bind_sessions, check the caller's session may play mark and bind it on first use.
+// A session holds at most one mark, so one device can't play both sides either.
+func claimSeat(ctx context.Context, game *Game, mark string) error {
+	if !game.BindSessions {
+		return nil
+	}
+	sessionID, _ := ctx.Value(runtime.RUNTIME_CTX_SESSION_ID).(string)
+	if sessionID == "" {
+		return errors.New("this game needs a session to move")
+	}
+	if owner, ok := game.seatSessions[mark]; ok {
+		if owner != sessionID {
+			return errSeatTaken
+		}
+		return nil
+	}
+	for m, owner := range game.seatSessions {
+		if owner == sessionID {
+			return fmt.Errorf("this session already plays %s", m)
+		}
+	}
+	if game.seatSessions == nil {
+		game.seatSessions = map[string]string{}
+	}
+	game.seatSessions[mark] = sessionID
+	return nil
 }
 
+// AuditEntry records an admin change made outside normal play
+type AuditEntry struct {
+	Action  string `json:"action"`
+	Actor   string `json:"actor"` // user id, "" for server calls
+	Cell    int    `json:"cell"`
+	Mark    string `json:"mark"` // "-" when the cell was cleared
+	Version int    `json:"version"`
+	At      int64  `json:"at"` // Unix milliseconds
+}
+
+// how many versions behind a client may be and still get a diff instead of a full snapshot
+const maxDiffVersions = 4
+
+// gamesMu only guards the games map itself, each game has its own mu for its state.
+// Lock order is always gamesMu before Game.mu, never the other way round.
 var (
 	gamesMu sync.RWMutex
 	games   = map[string]*Game{}
 )
 
+// lockGame: look up a game and lock it, callers must game.mu.Unlock() when done
+func lockGame(gid string) (*Game, error) {
+	gamesMu.RLock()
+	game, exists := games[normalizeGameID(gid)]
+	gamesMu.RUnlock()
+	if !exists {
+		return nil, errGameNotFound
+	}
+	game.mu.Lock()
+	// it may have been deleted between the map lookup and taking its lock
+	if game.removed {
+		game.mu.Unlock()
+		return nil, errGameNotFound
+	}
+	game.lastAccess = time.Now().UnixNano()
+	return game, nil
+}
+
+// evictGames: drop least recently used games until the map is within maxGames.
+// Finished games go first, active ones only if nothing finished is left. Caller must hold gamesMu.
+// Games whose lock is held are in use so they're skipped rather than waited for, since waiting
+// here would block every lookup behind one busy game. If every game is busy the map stays over
+// the cap until the next store.
+func evictGames() {
+	for maxGames > 0 && len(games) > maxGames {
+		// the best candidate so far stays locked so it can't change before it's removed
+		var victim *Game
+		victimFinished, victimAccess := false, int64(0)
+		for _, game := range games {
+			if !game.mu.TryLock() {
+				continue
+			}
+			finished, access := game.Winner != "", game.lastAccess
+			// a finished game always beats an active one, then the oldest access wins
+			if victim == nil || (finished && !victimFinished) || (finished == victimFinished && access < victimAccess) {
+				if victim != nil {
+					victim.mu.Unlock()
+				}
+				victim, victimFinished, victimAccess = game, finished, access
+			} else {
+				game.mu.Unlock()
+			}
+		}
+		if victim == nil {
+			return
+		}
+		victim.removed = true
+		if victimFinished {
+			evictedTotals.add(victim)
+		}
+		victim.mu.Unlock()
+		delete(games, victim.ID)
+	}
+}
+
+// evictedTotals adds up the finished games evicted since the server started. Guarded by gamesMu.
+var evictedTotals = newGameTotals()
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// the board is boardSize x boardSize, a line of boardSize marks wins
+const (
+	boardSize  = 3
+	boardCells = boardSize * boardSize
+)
+
+// marks in seating order, a game with N players uses the first N
+var allMarks = []string{"X", "O", "Z"}
+
 // helper: create empty board "---------"
 func newBoard() string {
 	return "---------"
 }
 
-// helper: generate simple id
+// helper: a stored board must have exactly one char per cell, anything else is corrupt
+func validBoard(board string) bool {
+	return len(board) == boardCells
+}
+
+// helper: generate a game id. It's random enough never to repeat, since it also keys the
+// game's archive record for good.
 func genID() string {
-	return fmt.Sprintf("g-%d", rand.Intn(1000000))
+	return "g-" + uuid.New().String()
 }
 
-// createGameRPC: create a new game and return payload as JSON string
-func createGameRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
-	id := genID()
+// helper: canonical form of a client-supplied game id. Ids are "g-" and a lowercase uuid, so
+// stray whitespace and case can't tell two games apart.
+func normalizeGameID(gid string) string {
+	return strings.ToLower(strings.TrimSpace(gid))
+}
+
+// helper: deterministic tie-break for no-draw games, the player holding the center wins
+func tieBreakWinner(board string) string {
+	return string(board[4])
+}
+
+// helper: human name for a cell, column letter then row number: 0 is A1, 4 is B2, 8 is C3
+func cellName(cell int) string {
+	return string(rune('A'+cell%boardSize)) + strconv.Itoa(cell/boardSize+1)
+}
+
+// helper: whether s is in list
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// helper: mark of the player after the current one
+func nextMark(game *Game) string {
+	return markAfter(game, game.Turn)
+}
+
+// helper: mark of the player seated after mark
+func markAfter(game *Game, mark string) string {
+	for i, m := range game.Marks {
+		if m == mark {
+			return game.Marks[(i+1)%len(game.Marks)]
+		}
+	}
+	return game.Marks[0]
+}
+
+// newGame: build a fresh game from validated create_game options, created by actor
+func newGame(req *CreateGameRequest, actor string) (*Game, error) {
+	players := 2
+	if req.Players != nil {
+		players = *req.Players
+	}
+
 	game := &Game{
-		ID:     id,
-		Board:  newBoard(),
-		Turn:   "X",
-		Winner: "",
+		ID:             genID(),
+		Board:          newBoard(),
+		Winner:         "",
+		NoDraw:         req.NoDraw,
+		Marks:          allMarks[:players],
+		History:        []Move{},
+		RequireConfirm: req.RequireConfirm,
+		Ranked:         req.Ranked,
+		Audit:          []AuditEntry{},
+		Spectators:     []string{},
+		WinLines:       req.WinLines,
+		AckMoves:       req.AckMoves,
+		MaxDistance:    req.MaxDistance,
+		BindSessions:   req.BindSessions,
+	}
+	if req.VsBot {
+		game.BotMark = game.Marks[1]
+		game.BotLevel = "best"
+		if req.BotDifficulty != "" {
+			game.BotLevel = req.BotDifficulty
+		}
+	}
+	switch req.First {
+	case "":
+		game.First = game.Marks[0]
+	case "random":
+		// drawn from a per-game seed so a replay with the same game id or seed gets the same starter
+		seed := gameSeed(game.ID)
+		if req.Seed != nil {
+			seed = *req.Seed
+		}
+		game.Seed = &seed
+		game.First = game.Marks[rand.New(rand.NewSource(seed)).Intn(players)]
+	default:
+		game.First = req.First
+	}
+	game.Turn = game.First
+	startClock(game, req.ClockSeconds)
+	addEvent(game, Event{Type: "created", Actor: actor})
+	return game, nil
+}
+
+// buildGame: newGame for an RPC caller, also starting from the requested preset
+func buildGame(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, req *CreateGameRequest) (*Game, error) {
+	game, err := newGame(req, callerID(ctx))
+	if err != nil || req.Preset == "" {
+		return game, err
+	}
+	preset, err := loadPreset(ctx, logger, nk, req.Preset)
+	if err != nil {
+		return nil, err
 	}
+	if err := applyPreset(game, preset); err != nil {
+		return nil, err
+	}
+	return game, nil
+}
+
+// helper: default seed for a game's random draws, derived from its id
+func gameSeed(gid string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(gid))
+	return int64(h.Sum64())
+}
 
+// helper: make a new game visible to the other RPCs, evicting old games past the cap
+func storeGame(game *Game) {
+	game.lastAccess = time.Now().UnixNano()
 	gamesMu.Lock()
-	games[id] = game
+	games[game.ID] = game
+	evictGames()
 	gamesMu.Unlock()
+}
 
-	resp := map[string]interface{}{
-		"ok":      true,
+// createGameRPC: create a new game and return payload as JSON string.
+// Optional payload: {"no_draw":true,"players":3,"require_confirm":true,"clock_seconds":60,"ranked":true}
+func createGameRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	// options are optional, an empty payload creates a standard game
+	var req CreateGameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	// a retry with a known key gets the game the first call made, as long as it's still around
+	caller, created := callerID(ctx), ""
+	if req.IdempotencyKey != "" {
+		for {
+			gid := claimKey(caller, req.IdempotencyKey)
+			if gid == "" {
+				break
+			}
+			if game, err := lockGame(gid); err == nil {
+				resp := createResponse(game)
+				resp["replayed"] = true
+				game.mu.Unlock()
+				b, _ := json.Marshal(resp)
+				return string(b), nil
+			}
+			forgetCreate(caller, req.IdempotencyKey, gid)
+		}
+		defer func() { releaseKey(caller, req.IdempotencyKey, created) }()
+	}
+
+	game, err := buildGame(ctx, logger, nk, &req)
+	if err != nil {
+		return "", err
+	}
+	playBotTurn(logger, game)
+
+	// build the response before the game is shared through the map
+	b, _ := json.Marshal(createResponse(game))
+
+	storeGame(game)
+	created = game.ID
+	logDebug(logger, map[string]interface{}{"game_id": game.ID, "no_draw": game.NoDraw}, "game created")
+	// Nakama RPC expects us to return a string; we'll return the JSON object as a string.
+	return string(b), nil
+}
+
+// helper: create_game's response for a game. Caller must hold game.mu once it's in the map.
+func createResponse(game *Game) map[string]interface{} {
+	return map[string]interface{}{
+		"ok":              true,
+		"game_id":         game.ID,
+		"board":           game.Board,
+		"turn":            game.Turn,
+		"no_draw":         game.NoDraw,
+		"marks":           game.Marks,
+		"require_confirm": game.RequireConfirm,
+		"ranked":          game.Ranked,
+		"clock":           clockState(game),
+	}
+}
+
+// helper: just the essential game state, used for {"format":"compact"} responses
+func compactGame(game *Game) map[string]interface{} {
+	return map[string]interface{}{
 		"game_id": game.ID,
 		"board":   game.Board,
 		"turn":    game.Turn,
+		"winner":  game.Winner,
+		"version": game.Version,
 	}
-	b, _ := json.Marshal(resp)
-	// Nakama RPC expects us to return a string; we'll return the JSON object as a string.
-	return string(b), nil
 }
 
-// makeMoveRPC: expects payload to be a JSON string (string content) containing {"game_id":"...","cell":index}
-func makeMoveRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
-	// payload arrives as a string (e.g. "{\"game_id\":\"g-123\",\"cell\":4}")
-	// First parse payload string into an object
-	var in map[string]interface{}
-	if err := json.Unmarshal([]byte(payload), &in); err != nil {
-		// If payload is itself already the JSON object string (escaped), try un-quoting
-		// but in our front-end we'll send properly, so this should be fine
-		return "", errors.New("invalid payload JSON")
-	}
-
-	gidRaw, ok := in["game_id"]
-	if !ok {
-		return "", errors.New("missing game_id")
-	}
-	gid := fmt.Sprintf("%v", gidRaw)
-
-	cellF, ok := in["cell"]
-	if !ok {
-		return "", errors.New("missing cell")
-	}
-
-	// cell could be float64 (from json.Number), convert to int
-	cell := 0
-	switch v := cellF.(type) {
-	case float64:
-		cell = int(v)
-	case int:
-		cell = v
-	case string:
-		n, _ := strconv.Atoi(v)
-		cell = n
-	default:
-		return "", errors.New("invalid cell index")
+// errors the versioned RPCs report with their own status codes
+var (
+	errGameNotFound    = errors.New("game not found")
+	errGameFinished    = errors.New("game already finished")
+	errGameNotFinished = errors.New("game not finished")
+	errAuthRequired    = errors.New("authentication required")
+	errSeatTaken       = errors.New("seat taken by another session")
+)
+
+// returned when the payload claims a mark that isn't to move. It doesn't count towards
+// maxIllegalMoves: anyone can send it, so it can't be pinned on the player to move.
+var errWrongMark = errors.New("wrong mark")
+
+// errors for moves the player shouldn't have tried, these count towards maxIllegalMoves
+var (
+	errCellOccupied = errors.New("cell already occupied")
+	errMoveTooFast  = errors.New("move too fast")
+	errTooFar       = errors.New("move too far from the other marks")
+)
+
+// cellRangeError is returned for a cell off the board and names the valid range for that board
+type cellRangeError struct {
+	cells int
+}
+
+func (e cellRangeError) Error() string {
+	return fmt.Sprintf("cell must be 0–%d", e.cells-1)
+}
+
+// helper: errCellOccupied for canonical cell, naming it as shown so a reoriented client
+// sees its own cell name
+func occupiedError(game *Game, cell, shown int) error {
+	return fmt.Errorf("%w (%s by %c)", errCellOccupied, cellName(shown), game.Board[cell])
+}
+
+// helper: check cell is on a board with the given number of cells
+func checkCellRange(cell, cells int) error {
+	if cell < 0 || cell >= cells {
+		return cellRangeError{cells: cells}
+	}
+	return nil
+}
+
+// helper: whether err is the player's fault rather than the game's state
+func isIllegalMove(err error) bool {
+	var rangeErr cellRangeError
+	return errors.As(err, &rangeErr) || errors.Is(err, errCellOccupied) || err == errMoveTooFast || err == errTooFar
+}
+
+// recordIllegalMove: count an illegal attempt by the player to move and forfeit them once they
+// reach maxIllegalMoves, reports whether they forfeited. Only call it for attempts known to come
+// from that player. Caller must hold game.mu.
+func recordIllegalMove(game *Game) bool {
+	if maxIllegalMoves == 0 || game.Winner != "" || len(game.Marks) != 2 {
+		return false
 	}
-	if cell < 0 || cell > 8 {
-		return "", errors.New("cell index out of range")
+	if game.IllegalMoves == nil {
+		game.IllegalMoves = map[string]int{}
 	}
+	game.IllegalMoves[game.Turn]++
+	if game.IllegalMoves[game.Turn] < maxIllegalMoves {
+		return false
+	}
+	addEvent(game, Event{Type: "forfeit", Mark: game.Turn})
+	game.Winner = otherMark(game, game.Turn)
+	game.WinKind = "forfeit"
+	game.Points = winPoints["forfeit"]
+	game.Version++
+	addFinishEvent(game)
+	return true
+}
 
-	// find game
-	gamesMu.Lock()
-	game, exists := games[gid]
-	if !exists {
-		gamesMu.Unlock()
-		return "", errors.New("game not found")
+// helper: board as rows of single-mark strings, for {"board_format":"grid"}
+func boardGrid(board string) [][]string {
+	grid := make([][]string, boardSize)
+	for r := range grid {
+		grid[r] = make([]string, boardSize)
+		for c := range grid[r] {
+			grid[r][c] = string(board[r*boardSize+c])
+		}
+	}
+	return grid
+}
+
+// helper: board in the requested format, the flat string unless "grid" was asked for
+func boardView(board, boardFormat string) interface{} {
+	if boardFormat == "grid" {
+		return boardGrid(board)
+	}
+	return board
+}
+
+// helper: canonical cell shown at cell of a board viewed in orientation, "rotate90" turns the
+// board a quarter clockwise and "flip" mirrors it left to right. Cells off the board are kept.
+func canonicalCell(cell int, orientation string) int {
+	if checkCellRange(cell, boardCells) != nil {
+		return cell
+	}
+	r, c := cell/boardSize, cell%boardSize
+	switch orientation {
+	case "rotate90":
+		return (boardSize-1-c)*boardSize + r
+	case "flip":
+		return r*boardSize + boardSize - 1 - c
+	}
+	return cell
+}
+
+// helper: where a canonical cell appears on a board viewed in orientation
+func orientedCell(cell int, orientation string) int {
+	for i := 0; i < boardCells; i++ {
+		if canonicalCell(i, orientation) == cell {
+			return i
+		}
+	}
+	return cell
+}
+
+// helper: the board as seen in orientation
+func orientBoard(board, orientation string) string {
+	out := []byte(board)
+	for i := range out {
+		out[i] = board[canonicalCell(i, orientation)]
+	}
+	return string(out)
+}
+
+// helper: whether orientation differs from the canonical board
+func reoriented(orientation string) bool {
+	return orientation != "" && orientation != "normal"
+}
+
+// helper: copy of the game with every cell index it sends (win line, history and audit)
+// as seen in orientation. The board itself is left canonical for gameView to render.
+func orientGame(game *Game, orientation string) *Game {
+	if !reoriented(orientation) {
+		return game
+	}
+	c := game.Clone()
+	for i, cell := range c.WinLine {
+		c.WinLine[i] = orientedCell(cell, orientation)
+	}
+	for i := range c.History {
+		c.History[i].Cell = orientedCell(c.History[i].Cell, orientation)
+		c.History[i].Name = cellName(c.History[i].Cell)
+	}
+	for i := range c.Audit {
+		c.Audit[i].Cell = orientedCell(c.Audit[i].Cell, orientation)
+	}
+	return c
+}
+
+// helper: a game's timeline with event cells as seen in orientation
+func orientEvents(events []Event, orientation string) []Event {
+	if !reoriented(orientation) {
+		return events
+	}
+	out := make([]Event, len(events))
+	for i, e := range events {
+		if e.Cell != nil {
+			cell := orientedCell(*e.Cell, orientation)
+			e.Cell = &cell
+		}
+		out[i] = e
+	}
+	return out
+}
+
+// gameView: the game as it should appear in a response, full or compact, with the board
+// in the requested format and every cell in the requested orientation. Caller must hold game.mu.
+func gameView(game *Game, format, boardFormat, orientation string) interface{} {
+	var view map[string]interface{}
+	if format == "compact" {
+		view = compactGame(game)
+	} else if boardFormat == "grid" || reoriented(orientation) {
+		// round-trip through JSON so the board can be swapped out of the full game
+		b, _ := json.Marshal(orientGame(game, orientation))
+		json.Unmarshal(b, &view)
+	} else {
+		return game
+	}
+	view["board"] = boardView(orientBoard(game.Board, orientation), boardFormat)
+	return view
+}
+
+// helper: a game's fixed settings, without any of its changing state
+func gameConfig(game *Game) map[string]interface{} {
+	return map[string]interface{}{
+		"game_id":         game.ID,
+		"size":            boardSize,
+		"win_length":      boardSize,
+		"marks":           game.Marks,
+		"no_draw":         game.NoDraw,
+		"require_confirm": game.RequireConfirm,
+		"ranked":          game.Ranked,
+		"clock_ms":        game.ClockMs,
+		"ack_moves":       game.AckMoves,
+		"bot_mark":        game.BotMark,
+		"bot_level":       game.BotLevel,
+		"max_distance":    game.MaxDistance,
+		"bind_sessions":   game.BindSessions,
+		"win_lines":       game.WinLines,
+		"first":           game.First,
+		"seed":            game.Seed,
+	}
+}
+
+// applyMove: validate and apply a move by actor for the side to play. Caller must hold game.mu.
+func applyMove(game *Game, cell int, actor string) error {
+	if !validBoard(game.Board) {
+		return errors.New("corrupt board")
+	}
+
+	if err := checkCellRange(cell, len(game.Board)); err != nil {
+		return err
+	}
+
+	// a player whose clock has run out loses before their move counts
+	if flagFall(game) {
+		return errors.New("out of time")
 	}
 
 	// if already finished:
 	if game.Winner != "" {
-		gamesMu.Unlock()
-		return "", errors.New("game already finished")
+		return errGameFinished
 	}
 
-	// check board
+	if game.AwaitingAck && !game.replaying {
+		return errors.New("acknowledge the last move first")
+	}
+
+	// check board, naming whose mark is in the way
 	if game.Board[cell] != '-' {
-		gamesMu.Unlock()
-		return "", errors.New("cell already occupied")
+		return occupiedError(game, cell, cell)
+	}
+	if !withinReach(game, game.Board, cell) {
+		return errTooFar
+	}
+
+	// in ranked games a reply faster than a human could think is treated as automation
+	if game.Ranked && minThinkMs > 0 && len(game.History) > 0 && !game.replaying {
+		if nowMs()-game.History[len(game.History)-1].At < minThinkMs {
+			return errMoveTooFast
+		}
 	}
 
 	// apply move
+	mover := game.Turn
 	boardRunes := []rune(game.Board)
-	boardRunes[cell] = rune(game.Turn[0]) // 'X' or 'O'
+	boardRunes[cell] = rune(game.Turn[0]) // 'X', 'O' or 'Z'
+	if checkInvariants {
+		if err := checkMoveInvariant(game, string(boardRunes), cell); err != nil {
+			return fmt.Errorf("%w: %v", errInternal, err)
+		}
+	}
 	game.Board = string(boardRunes)
+	game.Version++
+	delete(game.IllegalMoves, mover)
+	game.DrawAccepts = nil
+	game.History = append(game.History, Move{Cell: cell, Name: cellName(cell), Mark: game.Turn, Version: game.Version, At: nowMs(), Actor: actor})
+	addEvent(game, Event{Type: "move", Actor: actor, Cell: &cell, Mark: mover})
+	if !game.replaying {
+		movesServed.Add(1)
+	}
+
+	// check winner, otherwise pass the turn to the next player
+	finished := settleResult(game)
+	if !finished {
+		game.Turn = nextMark(game)
+	}
+	chargeClock(game, mover)
+	if finished {
+		addFinishEvent(game)
+	}
+	return nil
+}
 
-	// check winner
-	if winner := checkWinner(game.Board); winner != "" {
+// checkMoveInvariant: verify game.Turn playing cell, giving the board after, against state kept
+// apart from game.Turn. The board must change in exactly that cell, from empty to the mover's
+// mark; the mover must be whoever follows the last move in History, or First for the opening
+// move; the marks already on the board must leave the mover the fewest, none more than one
+// ahead; and every move in History must still be on the board. Once an admin has edited the
+// game its board and history needn't agree, so only the first check applies. Call it before
+// changing the game.
+func checkMoveInvariant(game *Game, after string, cell int) error {
+	before, mark := game.Board, game.Turn
+	if len(before) != len(after) || !validBoard(after) {
+		return fmt.Errorf("board length changed from %d to %d", len(before), len(after))
+	}
+	changed := 0
+	for i := 0; i < len(after); i++ {
+		if before[i] != after[i] {
+			changed++
+			if i != cell {
+				return fmt.Errorf("cell %d changed, expected only %d", i, cell)
+			}
+		}
+	}
+	if changed != 1 {
+		return fmt.Errorf("%d cells changed, expected 1", changed)
+	}
+	if before[cell] != '-' || string(after[cell]) != mark {
+		return fmt.Errorf("cell %d went from %c to %c, expected - to %s", cell, before[cell], after[cell], mark)
+	}
+	if len(game.Audit) > 0 {
+		return nil
+	}
+
+	if n := len(game.History); n == 0 && mark != game.First {
+		return fmt.Errorf("%s made the opening move, expected %s", mark, game.First)
+	} else if n > 0 && mark != markAfter(game, game.History[n-1].Mark) {
+		return fmt.Errorf("%s moved after %s, expected %s", mark, game.History[n-1].Mark, markAfter(game, game.History[n-1].Mark))
+	}
+	counts := map[string]int{}
+	for _, c := range before {
+		if c != '-' {
+			counts[string(c)]++
+		}
+	}
+	for _, m := range game.Marks {
+		if counts[m] < counts[mark] || counts[m] > counts[mark]+1 {
+			return fmt.Errorf("%s moved with %d marks on the board while %s has %d", mark, counts[mark], m, counts[m])
+		}
+	}
+	for _, m := range game.History {
+		if string(before[m.Cell]) != m.Mark {
+			return fmt.Errorf("%s's move on %s is missing from the board", m.Mark, m.Name)
+		}
+	}
+	return nil
+}
+
+// settleResult: (re)compute the winner from the board, reports whether the game is over.
+// A line always beats a full board: a last move that both fills the board and completes a
+// line is a win, so the draw and tie-break rules are only reached when no line is complete.
+func settleResult(game *Game) bool {
+	game.Winner, game.WinLine, game.WinKind, game.Points = "", nil, "", 0
+	if winner, line, kind := checkWinner(game, game.Board); winner != "" {
 		game.Winner = winner
+		game.WinLine = line
+		game.WinKind = kind
+		game.Points = winPoints[kind]
 	} else if !strings.Contains(game.Board, "-") {
-		game.Winner = "draw"
-	} else {
-		// switch turn
-		if game.Turn == "X" {
-			game.Turn = "O"
+		if game.NoDraw {
+			game.Winner = tieBreakWinner(game.Board)
+			game.WinKind = "tie_break"
+			game.Points = winPoints["tie_break"]
 		} else {
-			game.Turn = "X"
+			game.Winner = "draw"
+		}
+	}
+	return game.Winner != ""
+}
+
+// helper: whether cell is close enough to an existing mark under the game's max_distance rule.
+// Distance counts king steps, so diagonal neighbours are 1 apart; the opening move is free.
+func withinReach(game *Game, board string, cell int) bool {
+	if game.MaxDistance == 0 {
+		return true
+	}
+	opening := true
+	for i := 0; i < len(board); i++ {
+		if board[i] == '-' {
+			continue
+		}
+		opening = false
+		dr, dc := i/boardSize-cell/boardSize, i%boardSize-cell%boardSize
+		if dr < 0 {
+			dr = -dr
+		}
+		if dc < 0 {
+			dc = -dc
+		}
+		if dr <= game.MaxDistance && dc <= game.MaxDistance {
+			return true
 		}
 	}
+	return opening
+}
 
-	// persist back
-	games[gid] = game
-	gamesMu.Unlock()
+// helper: whether the side to move may play cell on board, empty and within reach
+func playable(game *Game, board string, cell int) bool {
+	return board[cell] == '-' && withinReach(game, board, cell)
+}
+
+// helper: whether a legal move on cell would finish the game, false for moves applyMove would reject
+func moveEndsGame(game *Game, cell int) bool {
+	if !validBoard(game.Board) || checkCellRange(cell, len(game.Board)) != nil || game.Winner != "" || !playable(game, game.Board, cell) {
+		return false
+	}
+	board := game.Board[:cell] + game.Turn + game.Board[cell+1:]
+	if winner, _, _ := checkWinner(game, board); winner != "" {
+		return true
+	}
+	return !strings.Contains(board, "-")
+}
+
+// helper: record an illegal make_move attempt, saying so in the error if it cost the game
+func illegalMove(game *Game, err error) error {
+	if recordIllegalMove(game) {
+		return fmt.Errorf("%v: too many illegal moves, game forfeited", err)
+	}
+	return err
+}
+
+// makeMoveRPC: expects payload to be a JSON string (string content) containing {"game_id":"...","cell":index}.
+// Games created with require_confirm also need "confirm":true on a game-ending move.
+// Pass "format":"compact" to get only the essential state back.
+func makeMoveRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	// payload arrives as a string (e.g. "{\"game_id\":\"g-123\",\"cell\":4}")
+	var req MakeMoveRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+	gid, cell := req.GameID, canonicalCell(int(*req.Cell), req.Orientation)
+
+	// find game
+	game, err := lockGame(gid)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	if game.Ranked && callerID(ctx) == "" {
+		return "", errAuthRequired
+	}
 
+	// the server always places game.Turn, a client claiming a different mark is out of sync or tampering
+	if req.Mark != "" && req.Mark != game.Turn {
+		return "", errWrongMark
+	}
+
+	// hold a game-ending move until the client confirms it
+	if game.RequireConfirm && !req.Confirm && moveEndsGame(game, cell) {
+		resp := map[string]interface{}{
+			"ok":                    true,
+			"applied":               false,
+			"confirmation_required": true,
+			"cell":                  int(*req.Cell),
+			"game":                  gameView(game, req.Format, req.BoardFormat, req.Orientation),
+		}
+		b, _ := json.Marshal(resp)
+		return string(b), nil
+	}
+
+	mark, version := game.Turn, game.Version
+	if err := claimSeat(ctx, game, mark); err != nil {
+		return "", err
+	}
+	if err := applyMove(game, cell, callerID(ctx)); err != nil {
+		if errors.Is(err, errCellOccupied) {
+			err = occupiedError(game, cell, int(*req.Cell))
+		}
+		// only charge the player to move for attempts that are theirs: the caller claimed their
+		// mark, or under bind_sessions claimSeat has just checked the session holds the seat
+		if isIllegalMove(err) && (req.Mark == mark || game.BindSessions) {
+			err = illegalMove(game, err)
+		}
+		// a rejected move can still end the game, on time or by forfeit
+		if game.Version != version {
+			broadcastGame(logger, nk, game)
+		}
+		return "", err
+	}
+	logMoveAnalytics(logger, game)
+	logDebug(logger, map[string]interface{}{"game_id": game.ID, "cell": cell, "mark": mark, "winner": game.Winner}, "move applied")
+	playBotTurn(logger, game)
+	broadcastGame(logger, nk, game)
+
+	if req.Format == "compact" {
+		b, _ := json.Marshal(map[string]interface{}{"ok": true, "game": gameView(game, req.Format, req.BoardFormat, req.Orientation)})
+		return string(b), nil
+	}
 	resp := map[string]interface{}{
 		"ok":     true,
-		"game":   game,
-		"board":  game.Board,
+		"game":   gameView(game, req.Format, req.BoardFormat, req.Orientation),
+		"board":  boardView(orientBoard(game.Board, req.Orientation), req.BoardFormat),
 		"turn":   game.Turn,
 		"winner": game.Winner,
 	}
@@ -159,51 +1000,403 @@ func makeMoveRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runt
 	return string(b), nil
 }
 
-// getGameRPC: return game by id, expects payload string like {"game_id":"..."}
-func getGameRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
-	var in map[string]interface{}
-	if err := json.Unmarshal([]byte(payload), &in); err != nil {
-		return "", errors.New("invalid payload JSON")
+// playMovesRPC: apply a sequence of moves in one go, expects {"game_id":"...","cells":[4,0,8]}.
+// Moves alternate turns and stop at the first illegal move or when the game ends. In require_confirm
+// games they also stop before a game-ending move unless the payload has "confirm":true.
+func playMovesRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req PlayMovesRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
 	}
-	gidRaw, ok := in["game_id"]
-	if !ok {
-		return "", errors.New("missing game_id")
+	gid := req.GameID
+
+	// hold the lock for the whole sequence so no other move can interleave
+	game, err := lockGame(gid)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	if game.Ranked && callerID(ctx) == "" {
+		return "", errAuthRequired
 	}
-	gid := fmt.Sprintf("%v", gidRaw)
 
-	gamesMu.RLock()
-	game, exists := games[gid]
-	gamesMu.RUnlock()
-	if !exists {
-		return "", errors.New("game not found")
+	applied, version := 0, game.Version
+	stopReason := ""
+	held := -1
+	for _, cell := range req.Cells {
+		if game.Winner != "" {
+			stopReason = "game finished"
+			break
+		}
+		if game.RequireConfirm && !req.Confirm && moveEndsGame(game, int(cell)) {
+			stopReason = "confirmation required"
+			held = int(cell)
+			break
+		}
+		if err := claimSeat(ctx, game, game.Turn); err != nil {
+			stopReason = err.Error()
+			break
+		}
+		if err := applyMove(game, int(cell), callerID(ctx)); err != nil {
+			stopReason = err.Error()
+			break
+		}
+		logMoveAnalytics(logger, game)
+		applied++
+		playBotTurn(logger, game)
+	}
+	logDebug(logger, map[string]interface{}{"game_id": game.ID, "applied": applied, "stop_reason": stopReason}, "moves applied")
+	// the game can change without a move applied, when the mover has run out of time
+	if game.Version != version {
+		broadcastGame(logger, nk, game)
+	}
+
+	resp := map[string]interface{}{
+		"ok":          true,
+		"game":        game,
+		"applied":     applied,
+		"stop_reason": stopReason,
+	}
+	if held >= 0 {
+		resp["confirmation_required"] = true
+		resp["cell"] = held
 	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// getGameRPC: return game by id, expects payload string like {"game_id":"..."}.
+// Pass "format":"compact" to get only the essential state back, "include_events":true for the timeline
+// and "include_eval":true for the position's minimax score. With "if_version":N only
+// {"not_modified":true} comes back until the game moves past version N.
+func getGameRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GetGameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	live, err := lockGameOrArchive(ctx, logger, nk, req.GameID)
+	if err != nil {
+		return "", err
+	}
+	if !validBoard(live.Board) {
+		live.mu.Unlock()
+		return "", errors.New("corrupt board")
+	}
+	checkFlag(logger, nk, live)
+	if req.IfVersion != nil && live.Version <= *req.IfVersion {
+		version := live.Version
+		live.mu.Unlock()
+		b, _ := json.Marshal(map[string]interface{}{"ok": true, "not_modified": true, "version": version})
+		return string(b), nil
+	}
+	// answer from a copy so moves on the game don't wait for the searches below
+	game := live.Clone()
+	live.mu.Unlock()
+
 	resp := map[string]interface{}{
 		"ok":   true,
-		"game": game,
+		"game": gameView(game, req.Format, req.BoardFormat, req.Orientation),
+	}
+	if clock := clockState(game); clock != nil {
+		resp["clock"] = clock
+	}
+	if game.Winner == "" && forcedDraw(game) {
+		resp["auto_draw_available"] = true
+	}
+	if req.IncludeEvents {
+		resp["events"] = orientEvents(game.Events, req.Orientation)
+	}
+	if req.IncludeEval {
+		if eval := positionEval(game); eval != nil {
+			resp["eval"] = eval
+		}
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// helper: coarse lifecycle status derived from the winner
+func gameStatus(game *Game) string {
+	if game.Winner != "" {
+		return "finished"
+	}
+	return "in_progress"
+}
+
+// getTurnRPC: just whose turn it is, for clients polling until it's their move. Expects {"game_id":"..."}.
+func getTurnRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	checkFlag(logger, nk, game)
+
+	resp := map[string]interface{}{
+		"ok":      true,
+		"turn":    game.Turn,
+		"status":  gameStatus(game),
+		"version": game.Version,
+	}
+	if game.Winner != "" {
+		resp["winner"] = game.Winner
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// getLastMoveRPC: only the most recent move and the status it left the game in, for clients
+// animating the opponent's move. Expects {"game_id":"..."}; there's no "move" before the first one.
+func getLastMoveRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGameOrArchive(ctx, logger, nk, req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	checkFlag(logger, nk, game)
+
+	resp := map[string]interface{}{
+		"ok":      true,
+		"status":  gameStatus(game),
+		"turn":    game.Turn,
+		"winner":  game.Winner,
+		"version": game.Version,
+	}
+	if len(game.History) > 0 {
+		resp["move"] = game.History[len(game.History)-1]
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// helper: stable hash of what a client renders, the board, the turn and the winner
+func boardHash(game *Game) string {
+	h := fnv.New64a()
+	h.Write([]byte(game.Board + "|" + game.Turn + "|" + game.Winner))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// getBoardHashRPC: hash of the rendered state so clients can skip re-rendering an unchanged board.
+// Expects {"game_id":"..."}.
+func getBoardHashRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	checkFlag(logger, nk, game)
+
+	resp := map[string]interface{}{
+		"ok":         true,
+		"board_hash": boardHash(game),
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// getGameConfigRPC: only the immutable configuration of a game, expects {"game_id":"..."}
+func getGameConfigRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+
+	resp := map[string]interface{}{
+		"ok":     true,
+		"config": gameConfig(game),
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// helper: Unix ms the game ended at, from its timeline
+func finishedAt(game *Game) int64 {
+	for i := len(game.Events) - 1; i >= 0; i-- {
+		switch e := game.Events[i]; e.Type {
+		case "win", "draw", "auto_draw":
+			return e.At
+		}
+	}
+	return 0
+}
+
+// getGameSummaryRPC: condensed report of a finished game for a results screen, expects {"game_id":"..."}
+func getGameSummaryRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGameOrArchive(ctx, logger, nk, req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	if game.Winner == "" {
+		return "", errGameNotFinished
+	}
+
+	summary := map[string]interface{}{
+		"game_id":     game.ID,
+		"winner":      game.Winner,
+		"win_kind":    game.WinKind,
+		"win_line":    game.WinLine,
+		"points":      game.Points,
+		"players":     game.Marks,
+		"player_meta": game.PlayerMeta,
+		"total_moves": len(game.History),
+		"duration_ms": finishedAt(game) - game.Events[0].At,
+	}
+	resp := map[string]interface{}{
+		"ok":      true,
+		"summary": summary,
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// getGameDiffRPC: return what changed since a version the client already has,
+// expects {"game_id":"...","known_version":N}. Falls back to a full snapshot when N is too old.
+func getGameDiffRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameDiffRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+	known := *req.KnownVersion
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+
+	resp := map[string]interface{}{
+		"ok":      true,
+		"version": game.Version,
+	}
+	if known == game.Version {
+		resp["changed"] = false
+		b, _ := json.Marshal(resp)
+		return string(b), nil
+	}
+	resp["changed"] = true
+
+	// collect the moves made after the known version; if they don't account for
+	// every version since then (or it's too far back) send the whole game instead
+	var moves []Move
+	for _, m := range game.History {
+		if m.Version > known {
+			moves = append(moves, m)
+		}
+	}
+	if known < 0 || known > game.Version || game.Version-known > maxDiffVersions || len(moves) != game.Version-known {
+		resp["full"] = true
+		resp["game"] = game
+		b, _ := json.Marshal(resp)
+		return string(b), nil
+	}
+
+	resp["full"] = false
+	resp["moves"] = moves
+	resp["turn"] = game.Turn
+	if game.Winner != "" {
+		resp["winner"] = game.Winner
+		resp["status"] = gameStatus(game)
 	}
 	b, _ := json.Marshal(resp)
 	return string(b), nil
 }
 
-// checkWinner: returns "X", "O", "" for none
-func checkWinner(board string) string {
-	winLines := [8][3]int{
-		{0, 1, 2},
-		{3, 4, 5},
-		{6, 7, 8},
-		{0, 3, 6},
-		{1, 4, 7},
-		{2, 5, 8},
-		{0, 4, 8},
-		{2, 4, 6},
+// getServerTimeRPC: return the server clock in Unix milliseconds so clients can sync countdowns
+func getServerTimeRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	resp := map[string]interface{}{
+		"ok":          true,
+		"server_time": time.Now().UnixMilli(),
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// most custom win lines a game may define
+const maxWinLines = 32
+
+// winning lines on the board with the kind of line each one is
+var winLines = []struct {
+	cells []int
+	kind  string
+}{
+	{[]int{0, 1, 2}, "row"},
+	{[]int{3, 4, 5}, "row"},
+	{[]int{6, 7, 8}, "row"},
+	{[]int{0, 3, 6}, "column"},
+	{[]int{1, 4, 7}, "column"},
+	{[]int{2, 5, 8}, "column"},
+	{[]int{0, 4, 8}, "diagonal"},
+	{[]int{2, 4, 6}, "diagonal"},
+}
+
+// points awarded to the winner for each kind of win
+var winPoints = map[string]int{
+	"row":       1,
+	"column":    1,
+	"diagonal":  2,
+	"custom":    1,
+	"tie_break": 1,
+	"timeout":   1,
+	"forfeit":   1,
+}
+
+// checkWinner: returns the winning mark or "" for none, plus the winning cells and kind of line.
+// Games with custom win lines only win on those, as kind "custom".
+func checkWinner(game *Game, board string) (string, []int, string) {
+	if game.WinLines != nil {
+		for _, line := range game.WinLines {
+			if mark := lineOwner(board, line); mark != "" {
+				return mark, line, "custom"
+			}
+		}
+		return "", nil, ""
 	}
 	for _, w := range winLines {
-		a := board[w[0]]
-		b := board[w[1]]
-		c := board[w[2]]
-		if a != '-' && a == b && b == c {
-			return string(a)
+		if mark := lineOwner(board, w.cells); mark != "" {
+			return mark, w.cells, w.kind
+		}
+	}
+	return "", nil, ""
+}
+
+// helper: the mark filling every cell of line, "" if it isn't complete
+func lineOwner(board string, line []int) string {
+	a := board[line[0]]
+	if a == '-' {
+		return ""
+	}
+	for _, cell := range line[1:] {
+		if board[cell] != a {
+			return ""
 		}
 	}
-	return ""
+	return string(a)
 }
@@ -0,0 +1,858 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a full game with no completed line, ending X O X / X O O / O X X with O in the center
+var drawnGame = []int{0, 1, 2, 4, 3, 5, 7, 6, 8}
+
+func TestFullBoardIsADraw(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, drawnGame...)
+
+	game := gameState(t, gid)
+	if game.Winner != "draw" {
+		t.Fatalf("winner = %q, want draw", game.Winner)
+	}
+}
+
+func TestNoDrawTieBreakGoesToCenter(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"no_draw":true}`)
+	playCells(t, gid, drawnGame...)
+
+	game := gameState(t, gid)
+	if game.Winner != "O" || game.WinKind != "tie_break" {
+		t.Fatalf("winner = %q (%s), want O by tie_break", game.Winner, game.WinKind)
+	}
+	if game.Board != "XOXXOOOXX" {
+		t.Fatalf("board = %s", game.Board)
+	}
+}
+
+func TestShortBoardIsCorrupt(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	game, _ := lockGame(gid)
+	game.Board = "XO--"
+	game.mu.Unlock()
+
+	if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":8`)); err == nil || err.Error() != "corrupt board" {
+		t.Errorf("make_move: err = %v, want corrupt board", err)
+	}
+	if _, err := callRPC(t, getGameRPC, gameRequest(gid)); err == nil || err.Error() != "corrupt board" {
+		t.Errorf("get_game: err = %v, want corrupt board", err)
+	}
+}
+
+func TestPlayMovesStopsAtIllegalMove(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+
+	resp := mustRPC(t, playMovesRPC, gameRequest(gid, `"cells":[0,3,3,4]`))
+	if resp["applied"] != 2.0 || resp["stop_reason"] != "cell already occupied (A2 by O)" {
+		t.Fatalf("applied %v, stop_reason %q", resp["applied"], resp["stop_reason"])
+	}
+	if game := gameState(t, gid); game.Board != "X--O-----" || game.Turn != "X" {
+		t.Fatalf("board %s turn %s", game.Board, game.Turn)
+	}
+}
+
+func TestPlayMovesStopsWhenGameEnds(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+
+	resp := mustRPC(t, playMovesRPC, gameRequest(gid, `"cells":[0,3,1,4,2,5]`))
+	if resp["applied"] != 5.0 || resp["stop_reason"] != "game finished" {
+		t.Fatalf("applied %v, stop_reason %q", resp["applied"], resp["stop_reason"])
+	}
+	if game := gameState(t, gid); game.Winner != "X" {
+		t.Fatalf("winner = %q, want X", game.Winner)
+	}
+}
+
+func TestServerTime(t *testing.T) {
+	before := time.Now().UnixMilli()
+	resp := mustRPC(t, getServerTimeRPC, ``)
+	after := time.Now().UnixMilli()
+	if st := int64(resp["server_time"].(float64)); st < before || st > after {
+		t.Fatalf("server_time %d outside [%d, %d]", st, before, after)
+	}
+}
+
+func TestGameDiffSinceRecentVersion(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4, 0, 8)
+
+	resp := mustRPC(t, getGameDiffRPC, gameRequest(gid, `"known_version":1`))
+	if resp["full"] != false || resp["version"] != 3.0 || resp["turn"] != "O" {
+		t.Fatalf("diff = %v", resp)
+	}
+	moves := resp["moves"].([]interface{})
+	if len(moves) != 2 || moves[0].(map[string]interface{})["cell"] != 0.0 || moves[1].(map[string]interface{})["cell"] != 8.0 {
+		t.Fatalf("moves = %v, want cells 0 and 8", moves)
+	}
+
+	if resp := mustRPC(t, getGameDiffRPC, gameRequest(gid, `"known_version":3`)); resp["changed"] != false {
+		t.Fatalf("current version: changed = %v", resp["changed"])
+	}
+}
+
+func TestGameDiffFromStaleVersionIsFull(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4, 0, 8, 2, 6)
+
+	resp := mustRPC(t, getGameDiffRPC, gameRequest(gid, `"known_version":0`))
+	if resp["full"] != true || resp["game"].(map[string]interface{})["board"] != "O-O-X-X-X" {
+		t.Fatalf("diff = %v", resp)
+	}
+}
+
+func TestThreePlayerGameCyclesAndZWins(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"players":3}`)
+
+	for i, want := range []string{"X", "O", "Z", "X"} {
+		if game := gameState(t, gid); game.Turn != want {
+			t.Fatalf("move %d: turn = %s, want %s", i, game.Turn, want)
+		}
+		playCells(t, gid, i)
+	}
+	playCells(t, gid, 4, 5, 7, 6, 8)
+
+	game := gameState(t, gid)
+	if game.Winner != "Z" || game.Board != "XOZXOZOXZ" {
+		t.Fatalf("winner %q board %s, want Z on the right column", game.Winner, game.Board)
+	}
+}
+
+func TestRequireConfirmHoldsGameEndingMove(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"require_confirm":true}`)
+	playCells(t, gid, 0, 3, 1, 4)
+
+	resp := mustRPC(t, makeMoveRPC, gameRequest(gid, `"cell":2`))
+	if resp["applied"] != false || resp["confirmation_required"] != true {
+		t.Fatalf("unconfirmed winning move: %v", resp)
+	}
+	if game := gameState(t, gid); game.Board != "XX-OO----" {
+		t.Fatalf("board = %s, the held move was applied", game.Board)
+	}
+
+	mustRPC(t, makeMoveRPC, gameRequest(gid, `"cell":2`, `"confirm":true`))
+	if game := gameState(t, gid); game.Winner != "X" {
+		t.Fatalf("winner = %q after confirming", game.Winner)
+	}
+}
+
+func TestPlayMovesStopsForConfirmation(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"require_confirm":true}`)
+
+	resp := mustRPC(t, playMovesRPC, gameRequest(gid, `"cells":[0,3,1,4,2]`))
+	if resp["applied"] != 4.0 || resp["stop_reason"] != "confirmation required" || resp["confirmation_required"] != true || resp["cell"] != 2.0 {
+		t.Fatalf("play_moves = %v", resp)
+	}
+	if game := gameState(t, gid); game.Winner != "" || game.Board != "XX-OO----" {
+		t.Fatalf("winner %q board %s, the game-ending move was applied", game.Winner, game.Board)
+	}
+
+	mustRPC(t, playMovesRPC, gameRequest(gid, `"cells":[2]`, `"confirm":true`))
+	if game := gameState(t, gid); game.Winner != "X" {
+		t.Fatalf("winner = %q after confirming", game.Winner)
+	}
+}
+
+func TestBusyGameDoesNotBlockOthers(t *testing.T) {
+	setupTest(t)
+	busy, other := createGame(t, `{}`), createGame(t, `{}`)
+	game, _ := lockGame(busy)
+	defer game.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := makeMoveRPC(context.Background(), newTestLogger(), nil, nil, gameRequest(other, `"cell":4`))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("move on the other game: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("a move on one game waited for another game's lock")
+	}
+}
+
+func TestCompactFormat(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+
+	resp := mustRPC(t, makeMoveRPC, gameRequest(gid, `"cell":4`, `"format":"compact"`))
+	if len(resp) != 2 {
+		t.Fatalf("compact make_move has extra fields: %v", resp)
+	}
+	game := mustRPC(t, getGameRPC, gameRequest(gid, `"format":"compact"`))["game"].(map[string]interface{})
+	want := map[string]interface{}{"game_id": gid, "board": "----X----", "turn": "O", "winner": "", "version": 1.0}
+	if len(game) != len(want) {
+		t.Fatalf("compact game = %v", game)
+	}
+	for k, v := range want {
+		if game[k] != v {
+			t.Errorf("%s = %v, want %v", k, game[k], v)
+		}
+	}
+}
+
+func TestEvictionSkipsBusyGames(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxGames, 2)
+	busy := createGame(t, `{}`)
+	idle := createGame(t, `{}`)
+	game, _ := lockGame(busy)
+	gameState(t, idle)
+	locked := true
+	defer func() {
+		if locked {
+			game.mu.Unlock()
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := createGameRPC(context.Background(), newTestLogger(), nil, nil, `{}`)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("create_game: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("storing a game waited for a busy game's lock")
+	}
+	game.mu.Unlock()
+	locked = false
+
+	// the busy game was accessed least recently, but it's in use so the idle one goes instead
+	if _, err := lockGame(idle); err != errGameNotFound {
+		t.Fatalf("idle game: err = %v, want it evicted", err)
+	}
+	gameState(t, busy)
+}
+
+func TestEvictionPrefersFinishedGames(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxGames, 2)
+	finished := createGame(t, `{}`)
+	active := createGame(t, `{}`)
+	playCells(t, active, 4)
+	playCells(t, finished, 0, 3, 1, 4, 2)
+	createGame(t, `{}`)
+
+	if _, err := lockGame(finished); err != errGameNotFound {
+		t.Fatalf("finished game: err = %v, want it evicted", err)
+	}
+	gameState(t, active)
+
+	// the evicted game still counts
+	stats := mustRPC(t, getStatisticsRPC, `{}`)
+	if stats["games_played"] != 1.0 || stats["in_progress"] != 2.0 || stats["average_moves"] != 5.0 {
+		t.Fatalf("stats = %v", stats)
+	}
+}
+
+func TestMakeMoveRejectsWrongMark(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+
+	if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":4`, `"mark":"O"`)); err != errWrongMark {
+		t.Fatalf("O on X's turn: err = %v, want %v", err, errWrongMark)
+	}
+	if game := gameState(t, gid); game.Board != newBoard() || game.Turn != "X" {
+		t.Fatalf("board %s turn %s after a rejected move", game.Board, game.Turn)
+	}
+	mustRPC(t, makeMoveRPC, gameRequest(gid, `"cell":4`, `"mark":"X"`))
+}
+
+func TestGameConfigHasOnlyFixedSettings(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"no_draw":true,"clock_seconds":30}`)
+	playCells(t, gid, 4)
+
+	config := mustRPC(t, getGameConfigRPC, gameRequest(gid))["config"].(map[string]interface{})
+	if config["no_draw"] != true || config["clock_ms"] != 30000.0 || config["size"] != 3.0 || len(config["marks"].([]interface{})) != 2 {
+		t.Fatalf("config = %v", config)
+	}
+	for _, field := range []string{"board", "turn", "winner", "version", "history"} {
+		if _, ok := config[field]; ok {
+			t.Errorf("config has game state %q", field)
+		}
+	}
+}
+
+func TestIllegalMovesForfeitThePlayerToMove(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxIllegalMoves, 3)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4)
+
+	for i := 0; i < 2; i++ {
+		if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":4`, `"mark":"O"`)); err == nil {
+			t.Fatal("move onto an occupied cell was accepted")
+		}
+	}
+	_, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":9`, `"mark":"O"`))
+	if err == nil || err.Error() != "cell must be 0–8: too many illegal moves, game forfeited" {
+		t.Fatalf("third illegal move: err = %v", err)
+	}
+	if game := gameState(t, gid); game.Winner != "X" || game.WinKind != "forfeit" {
+		t.Fatalf("winner %q by %q, want X by forfeit", game.Winner, game.WinKind)
+	}
+}
+
+func TestLegalMoveResetsIllegalCount(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxIllegalMoves, 2)
+	gid := createGame(t, `{}`)
+
+	callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":9`, `"mark":"X"`))
+	mustRPC(t, makeMoveRPC, gameRequest(gid, `"cell":4`, `"mark":"X"`))
+	mustRPC(t, makeMoveRPC, gameRequest(gid, `"cell":0`, `"mark":"O"`))
+	callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":9`, `"mark":"X"`))
+	if game := gameState(t, gid); game.Winner != "" || game.IllegalMoves["X"] != 1 {
+		t.Fatalf("winner %q, X illegal moves %d", game.Winner, game.IllegalMoves["X"])
+	}
+}
+
+func TestUnattributedIllegalMovesDontForfeit(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxIllegalMoves, 2)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4)
+
+	// anyone can claim the wrong mark or send a bad move without one, so neither is O's doing
+	for i := 0; i < 5; i++ {
+		if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":0`, `"mark":"X"`)); err != errWrongMark {
+			t.Fatalf("err = %v, want %v", err, errWrongMark)
+		}
+		if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":4`)); err == nil {
+			t.Fatal("move onto an occupied cell was accepted")
+		}
+	}
+	if game := gameState(t, gid); game.Winner != "" || game.IllegalMoves["O"] != 0 {
+		t.Fatalf("winner %q, O illegal moves %d", game.Winner, game.IllegalMoves["O"])
+	}
+}
+
+func TestBoundSessionIllegalMovesCount(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxIllegalMoves, 2)
+	gid := createGame(t, `{"bind_sessions":true}`)
+	x, o := userContext("u1", "s1"), userContext("u2", "s2")
+	if _, err := callRPCWith(t, x, nil, makeMoveRPC, gameRequest(gid, `"cell":4`)); err != nil {
+		t.Fatal(err)
+	}
+
+	callRPCWith(t, o, nil, makeMoveRPC, gameRequest(gid, `"cell":4`))
+	_, err := callRPCWith(t, o, nil, makeMoveRPC, gameRequest(gid, `"cell":4`))
+	if err == nil || err.Error() != "cell already occupied (B2 by X): too many illegal moves, game forfeited" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestCloneCopiesEveryField(t *testing.T) {
+	seed, cell := int64(7), 4
+	game := &Game{
+		ID: "g-1", Board: "X---O----", Turn: "X", Winner: "", NoDraw: true, Marks: []string{"X", "O"},
+		IllegalMoves: map[string]int{"X": 1}, DrawAccepts: []string{"O"},
+		RequireConfirm: true, Ranked: true,
+		ClockMs: 5000, RemainingMs: map[string]int64{"X": 4000, "O": 5000}, TurnStartedAt: 1000, AckMoves: true, AwaitingAck: true,
+		WinLine: []int{0, 1, 2}, WinLines: [][]int{{0, 1, 2}}, WinKind: "row", Points: 1,
+		BotMark: "O", BotLevel: "best", MaxDistance: 1, BindSessions: true,
+		First: "X", Seed: &seed, Version: 2,
+		History:      []Move{{Cell: 0, Name: "A1", Mark: "X", Version: 1}},
+		Audit:        []AuditEntry{{Action: "set_cell", Cell: 4, Mark: "O", Version: 2}},
+		Spectators:   []string{"u3"},
+		PlayerMeta:   map[string]PlayerMeta{"X": {DisplayName: "Ann"}},
+		Events:       []Event{{Type: "move", Cell: &cell, Mark: "X", Version: 1}},
+		seatSessions: map[string]string{"X": "s1"},
+
+		spectatorSessions: map[string]map[string]bool{"u3": {"s3": true}},
+	}
+	// every field the test can set must be set, so a field Clone forgets shows up below
+	v := reflect.ValueOf(game).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		switch name := v.Type().Field(i).Name; name {
+		case "Winner", "mu", "removed", "lastAccess", "replaying":
+		default:
+			if v.Field(i).IsZero() {
+				t.Fatalf("test game leaves %s unset", name)
+			}
+		}
+	}
+
+	c := game.Clone()
+	if !reflect.DeepEqual(c, game) {
+		t.Fatalf("clone differs:\n%+v\n%+v", c, game)
+	}
+
+	c.Marks[0], c.IllegalMoves["X"], c.DrawAccepts[0], c.RemainingMs["X"] = "Z", 9, "X", 0
+	c.WinLine[0], c.History[0].Cell, c.Audit[0].Cell, c.Spectators[0] = 8, 8, 8, "u9"
+	c.PlayerMeta["X"], c.Events[0].Type, c.seatSessions["X"] = PlayerMeta{}, "win", "s9"
+	c.spectatorSessions["u3"]["s9"] = true
+	if game.Marks[0] != "X" || game.IllegalMoves["X"] != 1 || game.DrawAccepts[0] != "O" || game.RemainingMs["X"] != 4000 ||
+		game.WinLine[0] != 0 || game.History[0].Cell != 0 || game.Audit[0].Cell != 4 || game.Spectators[0] != "u3" ||
+		game.PlayerMeta["X"].DisplayName != "Ann" || game.Events[0].Type != "move" || game.seatSessions["X"] != "s1" ||
+		len(game.spectatorSessions["u3"]) != 1 {
+		t.Fatalf("changing the clone changed the original: %+v", game)
+	}
+}
+
+func TestGridBoardFormat(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+
+	resp := mustRPC(t, makeMoveRPC, gameRequest(gid, `"cell":5`, `"board_format":"grid"`))
+	want := [][]string{{"-", "-", "-"}, {"-", "-", "X"}, {"-", "-", "-"}}
+	for _, board := range []interface{}{resp["board"], resp["game"].(map[string]interface{})["board"]} {
+		b, _ := json.Marshal(board)
+		w, _ := json.Marshal(want)
+		if string(b) != string(w) {
+			t.Fatalf("board = %s, want %s", b, w)
+		}
+	}
+	if _, err := callRPC(t, getGameRPC, gameRequest(gid, `"board_format":"matrix"`)); err == nil || err.Error() != "invalid board_format" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestOutOfRangeErrorNamesTheRange(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	for _, cell := range []int{-1, 9} {
+		if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, fmt.Sprintf(`"cell":%d`, cell))); err == nil || err.Error() != "cell must be 0–8" {
+			t.Errorf("cell %d: err = %v", cell, err)
+		}
+	}
+}
+
+func TestCellNames(t *testing.T) {
+	for cell, want := range []string{"A1", "B1", "C1", "A2", "B2", "C2", "A3", "B3", "C3"} {
+		if got := cellName(cell); got != want {
+			t.Errorf("cellName(%d) = %s, want %s", cell, got, want)
+		}
+	}
+
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 5)
+	if history := gameState(t, gid).History; history[0].Name != "C2" {
+		t.Fatalf("history = %+v", history)
+	}
+}
+
+func TestEventTimeline(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4, 2)
+
+	if _, ok := mustRPC(t, getGameRPC, gameRequest(gid))["events"]; ok {
+		t.Fatal("events sent without include_events")
+	}
+	events := mustRPC(t, getGameRPC, gameRequest(gid, `"include_events":true`))["events"].([]interface{})
+	var types []string
+	for _, e := range events {
+		types = append(types, e.(map[string]interface{})["type"].(string))
+	}
+	if fmt.Sprint(types) != "[created move move move move move win]" {
+		t.Fatalf("events = %v", types)
+	}
+	if last := events[len(events)-1].(map[string]interface{}); last["mark"] != "X" || last["version"] != 5.0 {
+		t.Fatalf("win event = %v", last)
+	}
+}
+
+func TestCustomWinLines(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"win_lines":[[0,8]]}`)
+
+	// the top row doesn't count in this game, the two corners do
+	playCells(t, gid, 0, 3, 1, 4, 2)
+	if game := gameState(t, gid); game.Winner != "" {
+		t.Fatalf("top row won a custom-lines game: %q", game.Winner)
+	}
+	playCells(t, gid, 5, 8)
+	game := gameState(t, gid)
+	if game.Winner != "X" || game.WinKind != "custom" || fmt.Sprint(game.WinLine) != "[0 8]" {
+		t.Fatalf("winner %q by %q on %v", game.Winner, game.WinKind, game.WinLine)
+	}
+
+	for payload, want := range map[string]string{
+		`{"win_lines":[]}`:      "win_lines must have 1-32 lines",
+		`{"win_lines":[[4]]}`:   "win line needs at least 2 cells",
+		`{"win_lines":[[4,4]]}`: "win line repeats a cell",
+		`{"win_lines":[[4,9]]}`: "cell must be 0–8",
+	} {
+		if _, err := callRPC(t, createGameRPC, payload); err == nil || err.Error() != want {
+			t.Errorf("%s: err = %v, want %s", payload, err, want)
+		}
+	}
+}
+
+func TestGameSummary(t *testing.T) {
+	setupTest(t)
+	advance := setClock(t, 1000)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4)
+	if _, err := callRPC(t, getGameSummaryRPC, gameRequest(gid)); err != errGameNotFinished {
+		t.Fatalf("unfinished game: err = %v", err)
+	}
+
+	advance(4200)
+	playCells(t, gid, 2)
+	summary := mustRPC(t, getGameSummaryRPC, gameRequest(gid))["summary"].(map[string]interface{})
+	if summary["winner"] != "X" || summary["win_kind"] != "row" || summary["total_moves"] != 5.0 || summary["duration_ms"] != 4200.0 {
+		t.Fatalf("summary = %v", summary)
+	}
+}
+
+func TestGameIDLookupIgnoresCaseAndSpace(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+
+	mustRPC(t, makeMoveRPC, gameRequest(" "+strings.ToUpper(gid)+"\t", `"cell":4`))
+	if resp := mustRPC(t, getGameRPC, gameRequest(strings.ToUpper(gid))); resp["game"].(map[string]interface{})["board"] != "----X----" {
+		t.Fatalf("get_game = %v", resp)
+	}
+}
+
+func TestWinningMoveOnAFullBoardIsAWin(t *testing.T) {
+	setupTest(t)
+	for _, options := range []string{`{}`, `{"no_draw":true}`} {
+		gid := createGame(t, options)
+		// X's last move fills the board and completes the 0-4-8 diagonal
+		playCells(t, gid, 0, 2, 1, 3, 5, 6, 4, 7, 8)
+
+		game := gameState(t, gid)
+		if game.Winner != "X" || game.WinKind != "diagonal" || strings.Contains(game.Board, "-") {
+			t.Fatalf("%s: winner %q by %q on %s, want X on the diagonal", options, game.Winner, game.WinKind, game.Board)
+		}
+	}
+}
+
+func TestGetTurn(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4)
+
+	resp := mustRPC(t, getTurnRPC, gameRequest(gid))
+	if resp["turn"] != "O" || resp["status"] != "in_progress" || resp["version"] != 1.0 {
+		t.Fatalf("get_turn = %v", resp)
+	}
+	if _, ok := resp["game"]; ok {
+		t.Fatal("get_turn sent the whole game")
+	}
+	playCells(t, gid, 0, 1, 8, 7)
+	if resp := mustRPC(t, getTurnRPC, gameRequest(gid)); resp["status"] != "finished" {
+		t.Fatalf("get_turn after the end = %v", resp)
+	}
+}
+
+func TestBoardHashTracksRenderedState(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	hash := func() string { return mustRPC(t, getBoardHashRPC, gameRequest(gid))["board_hash"].(string) }
+
+	start := hash()
+	mustRPC(t, setPlayerMetaRPC, gameRequest(gid, `"mark":"X"`, `"display_name":"Ann"`))
+	if hash() != start {
+		t.Fatal("hash changed without a move")
+	}
+	playCells(t, gid, 4)
+	moved := hash()
+	if moved == start || len(moved) != 16 {
+		t.Fatalf("hash %q after a move, was %q", moved, start)
+	}
+	if other := createGame(t, `{}`); mustRPC(t, getBoardHashRPC, gameRequest(other))["board_hash"] != start {
+		t.Fatal("two empty boards hash differently")
+	}
+}
+
+func TestOrientationAppliesToEveryCell(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+
+	// top-left as drawn rotated a quarter clockwise is the canonical bottom-left
+	resp := mustRPC(t, makeMoveRPC, gameRequest(gid, `"cell":0`, `"orientation":"rotate90"`))
+	if game := gameState(t, gid); game.Board != "------X--" {
+		t.Fatalf("canonical board = %s", game.Board)
+	}
+	view := resp["game"].(map[string]interface{})
+	move := view["history"].([]interface{})[0].(map[string]interface{})
+	if view["board"] != "X--------" || move["cell"] != 0.0 || move["name"] != "A1" {
+		t.Fatalf("rotated view: board %v, move %v", view["board"], move)
+	}
+
+	_, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":0`, `"orientation":"rotate90"`))
+	if err == nil || err.Error() != "cell already occupied (A1 by X)" {
+		t.Fatalf("occupied: err = %v", err)
+	}
+
+	resp = mustRPC(t, getGameRPC, gameRequest(gid, `"orientation":"rotate90"`, `"include_events":true`))
+	events := resp["events"].([]interface{})
+	if cell := events[len(events)-1].(map[string]interface{})["cell"]; cell != 0.0 {
+		t.Fatalf("move event cell = %v", cell)
+	}
+	// the canonical view is untouched
+	if move := gameState(t, gid).History[0]; move.Cell != 6 || move.Name != "A3" {
+		t.Fatalf("stored move = %+v", move)
+	}
+}
+
+func TestOrientedWinLine(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	// X takes the canonical top row, which is the right column when rotated
+	playCells(t, gid, 0, 3, 1, 4, 2)
+
+	view := mustRPC(t, getGameRPC, gameRequest(gid, `"orientation":"rotate90"`))["game"].(map[string]interface{})
+	if fmt.Sprint(view["win_line"]) != "[2 5 8]" || view["board"] != "-OX-OX--X" {
+		t.Fatalf("win_line %v board %v", view["win_line"], view["board"])
+	}
+}
+
+func TestOrientedConfirmationEcho(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"require_confirm":true}`)
+	playCells(t, gid, 0, 3, 1, 4)
+
+	// canonical 2 completes the top row, it's drawn at 8 when rotated
+	resp := mustRPC(t, makeMoveRPC, gameRequest(gid, `"cell":8`, `"orientation":"rotate90"`))
+	view := resp["game"].(map[string]interface{})
+	if resp["confirmation_required"] != true || resp["cell"] != 8.0 || view["board"] != "-OX-OX---" {
+		t.Fatalf("confirmation = %v", resp)
+	}
+}
+
+func TestRankedMovesNeedAUser(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"ranked":true}`)
+
+	if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":4`)); err != errAuthRequired {
+		t.Fatalf("make_move from the server: err = %v, want %v", err, errAuthRequired)
+	}
+	if _, err := callRPC(t, playMovesRPC, gameRequest(gid, `"cells":[4]`)); err != errAuthRequired {
+		t.Fatalf("play_moves from the server: err = %v, want %v", err, errAuthRequired)
+	}
+	if _, err := callRPCWith(t, userContext("u1", "s1"), nil, makeMoveRPC, gameRequest(gid, `"cell":4`)); err != nil {
+		t.Fatalf("user move: %v", err)
+	}
+	if move := gameState(t, gid).History[0]; move.Actor != "u1" {
+		t.Fatalf("actor = %q", move.Actor)
+	}
+}
+
+func TestOccupiedErrorNamesTheMark(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4, 8)
+
+	for cell, want := range map[int]string{4: "cell already occupied (B2 by X)", 8: "cell already occupied (C3 by O)"} {
+		_, err := callRPC(t, makeMoveRPC, gameRequest(gid, fmt.Sprintf(`"cell":%d`, cell)))
+		if err == nil || err.Error() != want || !errors.Is(err, errCellOccupied) {
+			t.Errorf("cell %d: err = %v, want %s", cell, err, want)
+		}
+	}
+}
+
+func TestMaxDistance(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"max_distance":1}`)
+
+	// the opening move is free, after that every move must touch a mark
+	playCells(t, gid, 0)
+	if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":8`)); err != errTooFar {
+		t.Fatalf("cell 8 two steps from A1: err = %v, want %v", err, errTooFar)
+	}
+	playCells(t, gid, 4, 8)
+	if game := gameState(t, gid); game.Board != "X---O---X" {
+		t.Fatalf("board = %s", game.Board)
+	}
+
+	if _, err := callRPC(t, createGameRPC, `{"max_distance":3}`); err == nil || err.Error() != "max_distance must be 0-2" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestGetGameIfVersion(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4)
+
+	resp := mustRPC(t, getGameRPC, gameRequest(gid, `"if_version":1`))
+	if resp["not_modified"] != true || resp["version"] != 1.0 || resp["game"] != nil {
+		t.Fatalf("unchanged game = %v", resp)
+	}
+	if resp := mustRPC(t, getGameRPC, gameRequest(gid, `"if_version":0`)); resp["game"] == nil {
+		t.Fatalf("changed game = %v", resp)
+	}
+}
+
+func TestInvariantCatchesCorruptTurn(t *testing.T) {
+	setupTest(t)
+	setValue(t, &checkInvariants, true)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4)
+	game, _ := lockGame(gid)
+	game.Turn = "X"
+	game.mu.Unlock()
+
+	_, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":0`))
+	if !errors.Is(err, errInternal) || err.Error() != "internal error: X moved after X, expected O" {
+		t.Fatalf("err = %v", err)
+	}
+	if game := gameState(t, gid); game.Board != "----X----" || game.Version != 1 || len(game.History) != 1 {
+		t.Fatalf("failed move changed the game: board %s version %d", game.Board, game.Version)
+	}
+}
+
+func TestInvariantCatchesBoardOutOfStep(t *testing.T) {
+	setupTest(t)
+	setValue(t, &checkInvariants, true)
+	cases := map[string]func(*Game){
+		"X moved with 2 marks on the board while O has 0": func(g *Game) { g.Board = "X---X----" },
+		"X's move on B2 is missing from the board":        func(g *Game) { g.Board = "O--------" },
+	}
+	for want, corrupt := range cases {
+		gid := createGame(t, `{}`)
+		playCells(t, gid, 4, 0)
+		game, _ := lockGame(gid)
+		corrupt(game)
+		game.mu.Unlock()
+
+		if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":8`)); err == nil || err.Error() != "internal error: "+want {
+			t.Errorf("err = %v, want %s", err, want)
+		}
+	}
+}
+
+func TestInvariantHoldsForLegalGames(t *testing.T) {
+	setupTest(t)
+	setValue(t, &checkInvariants, true)
+	for _, options := range []string{`{}`, `{"first":"O"}`, `{"players":3}`, `{"vs_bot":true}`, `{"vs_bot":true,"bot_difficulty":"mirror"}`} {
+		gid := createGame(t, options)
+		for game := gameState(t, gid); game.Winner == ""; game = gameState(t, gid) {
+			playCells(t, gid, strings.Index(game.Board, "-"))
+		}
+	}
+
+	// an admin correction doesn't trip the turn checks on later moves
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4)
+	mustRPC(t, adminSetCellRPC, gameRequest(gid, `"cell":8`, `"mark":"X"`))
+	playCells(t, gid, 0)
+}
+
+func TestGetLastMove(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	if resp := mustRPC(t, getLastMoveRPC, gameRequest(gid)); resp["move"] != nil || resp["turn"] != "X" {
+		t.Fatalf("before any move = %v", resp)
+	}
+
+	callRPCWith(t, userContext("u1", "s1"), nil, makeMoveRPC, gameRequest(gid, `"cell":4`))
+	callRPCWith(t, userContext("u2", "s2"), nil, makeMoveRPC, gameRequest(gid, `"cell":0`))
+	resp := mustRPC(t, getLastMoveRPC, gameRequest(gid))
+	move := resp["move"].(map[string]interface{})
+	if move["cell"] != 0.0 || move["mark"] != "O" || move["actor"] != "u2" || resp["turn"] != "X" || resp["version"] != 2.0 {
+		t.Fatalf("get_last_move = %v", resp)
+	}
+}
+
+func TestBindSessions(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"bind_sessions":true}`)
+	x, o, intruder := userContext("u1", "s1"), userContext("u2", "s2"), userContext("u1", "s3")
+
+	if _, err := callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":4`)); err == nil || err.Error() != "this game needs a session to move" {
+		t.Fatalf("server call: err = %v", err)
+	}
+	if _, err := callRPCWith(t, x, nil, makeMoveRPC, gameRequest(gid, `"cell":4`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callRPCWith(t, x, nil, makeMoveRPC, gameRequest(gid, `"cell":0`)); err == nil || err.Error() != "this session already plays X" {
+		t.Fatalf("X's session playing O: err = %v", err)
+	}
+	if _, err := callRPCWith(t, o, nil, makeMoveRPC, gameRequest(gid, `"cell":0`)); err != nil {
+		t.Fatal(err)
+	}
+	// the same user from another session still can't take X's seat
+	if _, err := callRPCWith(t, intruder, nil, makeMoveRPC, gameRequest(gid, `"cell":8`)); err != errSeatTaken {
+		t.Fatalf("other session on X: err = %v, want %v", err, errSeatTaken)
+	}
+	if _, err := callRPCWith(t, x, nil, makeMoveRPC, gameRequest(gid, `"cell":8`)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBindSessionsCoverDrawAndMeta(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"bind_sessions":true}`)
+	x, o := userContext("u1", "s1"), userContext("u2", "s2")
+	for i, cell := range []int{0, 1, 2, 4, 3, 5, 7, 6} {
+		ctx := x
+		if i%2 == 1 {
+			ctx = o
+		}
+		if _, err := callRPCWith(t, ctx, nil, makeMoveRPC, gameRequest(gid, fmt.Sprintf(`"cell":%d`, cell))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// X's session can't accept the dead draw, or set the metadata, for O
+	if _, err := callRPCWith(t, x, nil, acceptAutoDrawRPC, gameRequest(gid, `"mark":"X"`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callRPCWith(t, x, nil, acceptAutoDrawRPC, gameRequest(gid, `"mark":"O"`)); err != errSeatTaken {
+		t.Fatalf("X's session accepting for O: err = %v", err)
+	}
+	if _, err := callRPCWith(t, x, nil, setPlayerMetaRPC, gameRequest(gid, `"mark":"O"`, `"display_name":"Loser"`)); err != errSeatTaken {
+		t.Fatalf("X's session naming O: err = %v", err)
+	}
+	if game := gameState(t, gid); game.Winner != "" || game.PlayerMeta["O"] != (PlayerMeta{}) {
+		t.Fatalf("winner %q, O meta %+v", game.Winner, game.PlayerMeta["O"])
+	}
+
+	if _, err := callRPCWith(t, o, nil, setPlayerMetaRPC, gameRequest(gid, `"mark":"O"`, `"display_name":"Bo"`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callRPCWith(t, o, nil, acceptAutoDrawRPC, gameRequest(gid, `"mark":"O"`)); err != nil {
+		t.Fatal(err)
+	}
+	if game := gameState(t, gid); game.WinKind != "auto_draw" || game.PlayerMeta["O"].DisplayName != "Bo" {
+		t.Fatalf("win_kind %q, O meta %+v", game.WinKind, game.PlayerMeta["O"])
+	}
+}
+
+func TestGameIDsDontRepeat(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 10000; i++ {
+		id := genID()
+		if seen[id] || normalizeGameID(id) != id {
+			t.Fatalf("id %s repeated or not canonical", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"sync"
+	"testing"
+)
+
+// Shared test helpers. RPCs are called directly, as server-to-server calls unless a test
+// builds its own ctx, and every test starts with an empty games map.
+
+// testLogger is a runtime.Logger that keeps every line, so tests can assert on what was logged
+type testLogger struct {
+	mu     *sync.Mutex
+	lines  *[]logLine
+	fields map[string]interface{}
+}
+
+// logLine is one captured log call
+type logLine struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+func newTestLogger() *testLogger {
+	return &testLogger{mu: &sync.Mutex{}, lines: &[]logLine{}, fields: map[string]interface{}{}}
+}
+
+func (l *testLogger) log(level, format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.lines = append(*l.lines, logLine{level: level, msg: fmt.Sprintf(format, v...), fields: l.fields})
+}
+
+func (l *testLogger) Debug(format string, v ...interface{}) { l.log("debug", format, v...) }
+func (l *testLogger) Info(format string, v ...interface{})  { l.log("info", format, v...) }
+func (l *testLogger) Warn(format string, v ...interface{})  { l.log("warn", format, v...) }
+func (l *testLogger) Error(format string, v ...interface{}) { l.log("error", format, v...) }
+
+func (l *testLogger) WithField(key string, v interface{}) runtime.Logger {
+	return l.WithFields(map[string]interface{}{key: v})
+}
+
+func (l *testLogger) WithFields(fields map[string]interface{}) runtime.Logger {
+	merged := map[string]interface{}{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &testLogger{mu: l.mu, lines: l.lines, fields: merged}
+}
+
+func (l *testLogger) Fields() map[string]interface{} { return l.fields }
+
+// helper: captured lines at level with exactly the message msg
+func (l *testLogger) find(level, msg string) []logLine {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []logLine
+	for _, line := range *l.lines {
+		if line.level == level && line.msg == msg {
+			found = append(found, line)
+		}
+	}
+	return found
+}
+
+// fakeNK is the part of the Nakama module the game code uses. Calling anything else panics
+// on the nil embedded interface.
+type fakeNK struct {
+	runtime.NakamaModule
+
+	mu      sync.Mutex
+	sent    []string          // data of every StreamSend, in order
+	storage map[string]string // stored values by storageKey
+}
+
+// helper: fakeNK storage key of an object
+func storageKey(collection, userID, key string) string {
+	return collection + "/" + userID + "/" + key
+}
+
+func (nk *fakeNK) StorageRead(ctx context.Context, reads []*runtime.StorageRead) ([]*api.StorageObject, error) {
+	nk.mu.Lock()
+	defer nk.mu.Unlock()
+	objects := []*api.StorageObject{}
+	for _, r := range reads {
+		if v, ok := nk.storage[storageKey(r.Collection, r.UserID, r.Key)]; ok {
+			objects = append(objects, &api.StorageObject{Collection: r.Collection, Key: r.Key, UserId: r.UserID, Value: v})
+		}
+	}
+	return objects, nil
+}
+
+func (nk *fakeNK) StorageWrite(ctx context.Context, writes []*runtime.StorageWrite) ([]*api.StorageObjectAck, error) {
+	nk.mu.Lock()
+	defer nk.mu.Unlock()
+	if nk.storage == nil {
+		nk.storage = map[string]string{}
+	}
+	acks := []*api.StorageObjectAck{}
+	for _, w := range writes {
+		nk.storage[storageKey(w.Collection, w.UserID, w.Key)] = w.Value
+		acks = append(acks, &api.StorageObjectAck{Collection: w.Collection, Key: w.Key, UserId: w.UserID})
+	}
+	return acks, nil
+}
+
+func (nk *fakeNK) StreamUserJoin(mode uint8, subject, subcontext, label, userID, sessionID string, hidden, persistence bool, status string) (bool, error) {
+	return true, nil
+}
+
+func (nk *fakeNK) StreamUserLeave(mode uint8, subject, subcontext, label, userID, sessionID string) error {
+	return nil
+}
+
+func (nk *fakeNK) StreamSend(mode uint8, subject, subcontext, label, data string, presences []runtime.Presence, reliable bool) error {
+	nk.mu.Lock()
+	defer nk.mu.Unlock()
+	nk.sent = append(nk.sent, data)
+	return nil
+}
+
+// setupTest: start a test with no games in memory or archived, no idempotency keys, and nobody spectating
+func setupTest(t *testing.T) {
+	t.Helper()
+	idempotencyMu.Lock()
+	idempotencyKeys = map[string][]idempotentCreate{}
+	idempotencyMu.Unlock()
+	spectatingMu.Lock()
+	spectating = map[string]map[string]bool{}
+	spectatingMu.Unlock()
+	gamesMu.Lock()
+	games = map[string]*Game{}
+	archivedTotals = newGameTotals()
+	evictedTotals = newGameTotals()
+	gamesMu.Unlock()
+}
+
+// setValue: change a package setting for the length of the test
+func setValue[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// callRPCWith: run an RPC with the given ctx and Nakama module and decode its JSON response
+func callRPCWith(t *testing.T, ctx context.Context, nk runtime.NakamaModule, fn rpcFunc, payload string) (map[string]interface{}, error) {
+	t.Helper()
+	out, err := fn(ctx, newTestLogger(), nil, nk, payload)
+	if err != nil {
+		return nil, err
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("response isn't JSON: %v: %s", err, out)
+	}
+	return resp, nil
+}
+
+// callRPC: run an RPC as a server-to-server call
+func callRPC(t *testing.T, fn rpcFunc, payload string) (map[string]interface{}, error) {
+	t.Helper()
+	return callRPCWith(t, context.Background(), nil, fn, payload)
+}
+
+// mustRPC: callRPC that fails the test on an error
+func mustRPC(t *testing.T, fn rpcFunc, payload string) map[string]interface{} {
+	t.Helper()
+	resp, err := callRPC(t, fn, payload)
+	if err != nil {
+		t.Fatalf("unexpected error for %s: %v", payload, err)
+	}
+	return resp
+}
+
+// createGame: create a game with the given create_game options and return its id
+func createGame(t *testing.T, options string) string {
+	t.Helper()
+	return mustRPC(t, createGameRPC, options)["game_id"].(string)
+}
+
+// gameRequest: payload naming gid plus extra fields, e.g. gameRequest(gid, `"cell":4`)
+func gameRequest(gid string, fields ...string) string {
+	payload := fmt.Sprintf(`{"game_id":%q`, gid)
+	for _, f := range fields {
+		payload += "," + f
+	}
+	return payload + "}"
+}
+
+// playCells: make each move in turn through make_move, failing the test on any error
+func playCells(t *testing.T, gid string, cells ...int) {
+	t.Helper()
+	for _, cell := range cells {
+		mustRPC(t, makeMoveRPC, gameRequest(gid, fmt.Sprintf(`"cell":%d`, cell)))
+	}
+}
+
+// gameState: a copy of a stored game's current state
+func gameState(t *testing.T, gid string) *Game {
+	t.Helper()
+	game, err := lockGame(gid)
+	if err != nil {
+		t.Fatalf("game %s: %v", gid, err)
+	}
+	defer game.mu.Unlock()
+	return game.Clone()
+}
+
+// userContext: ctx for a client call by userID from sessionID
+func userContext(userID, sessionID string) context.Context {
+	ctx := context.WithValue(context.Background(), runtime.RUNTIME_CTX_USER_ID, userID)
+	return context.WithValue(ctx, runtime.RUNTIME_CTX_SESSION_ID, sessionID)
+}
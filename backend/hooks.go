@@ -0,0 +1,25 @@
+package main
+
+// Game-end hooks let other Go code react to finished games (custom scoring, webhooks,
+// achievements) without touching the RPCs that finish them.
+
+// gameEndHooks run in registration order whenever a game finishes
+var gameEndHooks []func(*Game)
+
+// RegisterOnGameEnd adds fn to the callbacks run when any game finishes, however it ends:
+// a winning or drawing move, timeout, forfeit, auto-draw or an admin correction, or an
+// import_notation game that's already over, once it's stored. Register
+// from InitModule, before any game is played; registration isn't safe alongside live games.
+//
+// fn gets a copy of the finished game and runs synchronously while the game is locked, so it
+// must be quick and must not call back into RPCs for the same game.
+func RegisterOnGameEnd(fn func(*Game)) {
+	gameEndHooks = append(gameEndHooks, fn)
+}
+
+// helper: run the game-end hooks for a game that just finished. Caller must hold game.mu.
+func runGameEndHooks(game *Game) {
+	for _, fn := range gameEndHooks {
+		fn(game.Clone())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+// recordGameEnds: register a hook for the rest of the test, returning the games it was called with
+func recordGameEnds(t *testing.T) *[]*Game {
+	ended := &[]*Game{}
+	setValue(t, &gameEndHooks, nil)
+	RegisterOnGameEnd(func(game *Game) { *ended = append(*ended, game) })
+	return ended
+}
+
+func TestGameEndHooksRunOnceWithACopy(t *testing.T) {
+	setupTest(t)
+	ended := recordGameEnds(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 0, 3, 1, 4)
+	if len(*ended) != 0 {
+		t.Fatal("hook ran before the game ended")
+	}
+
+	playCells(t, gid, 2)
+	callRPC(t, makeMoveRPC, gameRequest(gid, `"cell":8`))
+	mustRPC(t, getGameRPC, gameRequest(gid))
+	if len(*ended) != 1 {
+		t.Fatalf("hook ran %d times, want 1", len(*ended))
+	}
+	got := (*ended)[0]
+	if got.ID != gid || got.Winner != "X" {
+		t.Fatalf("hook got %s won by %q", got.ID, got.Winner)
+	}
+	got.Winner, got.History[0].Cell = "O", 8
+	if game := gameState(t, gid); game.Winner != "X" || game.History[0].Cell != 0 {
+		t.Fatal("changing the hook's game changed the stored one")
+	}
+}
+
+func TestGameEndHooksRunForEveryEnding(t *testing.T) {
+	setupTest(t)
+	ended := recordGameEnds(t)
+
+	playCells(t, createGame(t, `{}`), drawnGame...)
+	forfeit := createGame(t, `{}`)
+	setValue(t, &maxIllegalMoves, 1)
+	callRPC(t, makeMoveRPC, gameRequest(forfeit, `"cell":9`, `"mark":"X"`))
+	advance := setClock(t, 1000)
+	timeout := createGame(t, `{"clock_seconds":1}`)
+	advance(2000)
+	mustRPC(t, getTurnRPC, gameRequest(timeout))
+
+	var kinds []string
+	for _, game := range *ended {
+		kinds = append(kinds, game.Winner+"/"+game.WinKind)
+	}
+	if len(kinds) != 3 || kinds[0] != "draw/" || kinds[1] != "O/forfeit" || kinds[2] != "O/timeout" {
+		t.Fatalf("hooks saw %v", kinds)
+	}
+}
+
+func TestImportedGamesEndOnceStored(t *testing.T) {
+	setupTest(t)
+	ended := recordGameEnds(t)
+
+	// moves past the end fail the import, so that game never existed
+	if _, err := callRPC(t, importNotationRPC, `{"notation":"0,3,1,4,2,5"}`); err == nil || err.Error() != "move 6: game already finished" {
+		t.Fatalf("err = %v", err)
+	}
+	if len(*ended) != 0 {
+		t.Fatalf("hooks saw %d games for a failed import", len(*ended))
+	}
+	gamesMu.RLock()
+	stored := len(games)
+	gamesMu.RUnlock()
+	if stored != 0 {
+		t.Fatalf("%d games stored", stored)
+	}
+
+	game := mustRPC(t, importNotationRPC, `{"notation":"0,3,1,4,2"}`)["game"].(map[string]interface{})
+	if len(*ended) != 1 || (*ended)[0].ID != game["game_id"] || (*ended)[0].Winner != "X" {
+		t.Fatalf("hooks saw %v", *ended)
+	}
+	gameState(t, game["game_id"].(string))
+	mustRPC(t, importNotationRPC, `{"notation":"0,3"}`)
+	if len(*ended) != 1 {
+		t.Fatal("hooks ran for an unfinished import")
+	}
+}
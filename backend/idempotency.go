@@ -0,0 +1,104 @@
+package main
+
+import "sync"
+
+// Idempotent creation: a create_game retried with the same idempotency_key gets the game the
+// first call made instead of a second one. Keys are remembered per caller, in memory only.
+
+// how many recent keys are kept for each caller, the oldest are forgotten first
+const maxIdempotencyKeys = 50
+
+// longest accepted idempotency_key, in bytes
+const maxIdempotencyKeyLen = 128
+
+// idempotentCreate is one remembered key and the game it created
+type idempotentCreate struct {
+	key    string
+	gameID string
+}
+
+// idempotencyMu guards idempotencyKeys and pendingCreates. It's only held to look keys up and
+// record them; a keyed create in progress is marked in pendingCreates instead, so retries racing
+// each other still make one game without holding up creates with other keys.
+var (
+	idempotencyMu   sync.Mutex
+	idempotencyKeys = map[string][]idempotentCreate{} // by caller user id, oldest first
+	pendingCreates  = map[string]chan struct{}{}      // by pendingKey, closed when that create finishes
+)
+
+// helper: pendingCreates key for caller's key
+func pendingKey(caller, key string) string {
+	return caller + "\x00" + key
+}
+
+// claimKey: the game caller's key created earlier, or "" once the caller has claimed the key and
+// must create the game and then call releaseKey. Waits while another create with the key is running.
+func claimKey(caller, key string) string {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+	for {
+		if gid := recentCreate(caller, key); gid != "" {
+			return gid
+		}
+		pk := pendingKey(caller, key)
+		wait, busy := pendingCreates[pk]
+		if !busy {
+			pendingCreates[pk] = make(chan struct{})
+			return ""
+		}
+		idempotencyMu.Unlock()
+		<-wait
+		idempotencyMu.Lock()
+	}
+}
+
+// releaseKey: end caller's claim on key, remembering gid as its game unless it's "" (the create failed)
+func releaseKey(caller, key, gid string) {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+	if gid != "" {
+		rememberCreate(caller, key, gid)
+	}
+	pk := pendingKey(caller, key)
+	close(pendingCreates[pk])
+	delete(pendingCreates, pk)
+}
+
+// forgetCreate: drop caller's key if it still points at gid, a game that's no longer in memory
+func forgetCreate(caller, key, gid string) {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+	kept := []idempotentCreate{}
+	for _, c := range idempotencyKeys[caller] {
+		if c.key != key || c.gameID != gid {
+			kept = append(kept, c)
+		}
+	}
+	idempotencyKeys[caller] = kept
+}
+
+// helper: game created earlier by caller with key, "" if there's none. Caller must hold idempotencyMu.
+func recentCreate(caller, key string) string {
+	for _, c := range idempotencyKeys[caller] {
+		if c.key == key {
+			return c.gameID
+		}
+	}
+	return ""
+}
+
+// helper: remember that caller's key created gid, replacing any older game for the key.
+// Caller must hold idempotencyMu.
+func rememberCreate(caller, key, gid string) {
+	kept := []idempotentCreate{}
+	for _, c := range idempotencyKeys[caller] {
+		if c.key != key {
+			kept = append(kept, c)
+		}
+	}
+	kept = append(kept, idempotentCreate{key: key, gameID: gid})
+	if len(kept) > maxIdempotencyKeys {
+		kept = kept[len(kept)-maxIdempotencyKeys:]
+	}
+	idempotencyKeys[caller] = kept
+}
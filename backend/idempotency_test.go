@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotentCreateReplays(t *testing.T) {
+	setupTest(t)
+	ctx := userContext("u1", "s1")
+	first, err := callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k1"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first["replayed"] != nil {
+		t.Fatalf("first create replayed: %v", first)
+	}
+	again, err := callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k1","players":3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again["game_id"] != first["game_id"] || again["replayed"] != true {
+		t.Fatalf("retry got %v, want game %v replayed", again, first["game_id"])
+	}
+
+	other, _ := callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k2"}`)
+	if other["game_id"] == first["game_id"] {
+		t.Fatal("a different key replayed the first game")
+	}
+	// keys are per caller
+	theirs, _ := callRPCWith(t, userContext("u2", "s2"), nil, createGameRPC, `{"idempotency_key":"k1"}`)
+	if theirs["game_id"] == first["game_id"] {
+		t.Fatal("another user's key replayed the first game")
+	}
+}
+
+func TestIdempotentCreateAfterGameRemoved(t *testing.T) {
+	setupTest(t)
+	ctx := userContext("u1", "s1")
+	first, _ := callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k1"}`)
+	gamesMu.Lock()
+	delete(games, first["game_id"].(string))
+	gamesMu.Unlock()
+
+	again, err := callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k1"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again["game_id"] == first["game_id"] || again["replayed"] != nil {
+		t.Fatalf("removed game was replayed: %v", again)
+	}
+	idempotencyMu.Lock()
+	gid := recentCreate("u1", "k1")
+	idempotencyMu.Unlock()
+	if gid != again["game_id"] {
+		t.Fatalf("key remembers %s, want the new game %v", gid, again["game_id"])
+	}
+}
+
+func TestIdempotentCreateConcurrentRetries(t *testing.T) {
+	setupTest(t)
+	ctx := userContext("u1", "s1")
+	ids := make([]interface{}, 8)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k1"}`)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids[i] = resp["game_id"]
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("racing retries made different games: %v", ids)
+		}
+	}
+	gamesMu.Lock()
+	n := len(games)
+	gamesMu.Unlock()
+	if n != 1 {
+		t.Fatalf("%d games stored, want 1", n)
+	}
+}
+
+func TestIdempotentCreateOnlyWaitsForItsKey(t *testing.T) {
+	setupTest(t)
+	ctx := userContext("u1", "s1")
+	// a create with k1 is in progress
+	if gid := claimKey("u1", "k1"); gid != "" {
+		t.Fatalf("fresh key already has game %s", gid)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k2"}`)
+		callRPCWith(t, ctx, nil, createGameRPC, ``)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("creates with other keys waited on k1")
+	}
+
+	retried := make(chan interface{})
+	go func() {
+		resp, _ := callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k1"}`)
+		retried <- resp["game_id"]
+	}()
+	select {
+	case id := <-retried:
+		t.Fatalf("retry with k1 didn't wait for the create in progress, got %v", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	gid := createGame(t, "")
+	releaseKey("u1", "k1", gid)
+	if id := <-retried; id != gid {
+		t.Fatalf("retry got %v, want %s from the finished create", id, gid)
+	}
+}
+
+func TestIdempotentCreateFailureReleasesKey(t *testing.T) {
+	setupTest(t)
+	ctx := userContext("u1", "s1")
+	if _, err := callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k1","players":9}`); err == nil {
+		t.Fatal("invalid create succeeded")
+	}
+	resp, err := callRPCWith(t, ctx, nil, createGameRPC, `{"idempotency_key":"k1"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp["replayed"] != nil {
+		t.Fatalf("failed create was remembered: %v", resp)
+	}
+}
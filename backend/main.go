@@ -3,10 +3,125 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"github.com/heroiclabs/nakama-common/runtime"
 	"log"
+	"runtime/debug"
+	"strings"
 )
 
+// rpcFunc is the handler signature expected by Nakama:
+// func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error)
+type rpcFunc = func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error)
+
+// RPCs exposed by the module, registered in this order
+var rpcs = []struct {
+	id string
+	fn rpcFunc
+}{
+	{"create_game", createGameRPC},
+	{"make_move", makeMoveRPC},
+	{"get_game", getGameRPC},
+	{"play_moves", playMovesRPC},
+	{"get_server_time", getServerTimeRPC},
+	{"get_game_diff", getGameDiffRPC},
+	{"admin_set_cell", adminSetCellRPC},
+	{"get_statistics", getStatisticsRPC},
+	{"export_notation", exportNotationRPC},
+	{"import_notation", importNotationRPC},
+	{"rank_moves", rankMovesRPC},
+	{"get_game_with_eta", getGameWithEtaRPC},
+	{"get_game_config", getGameConfigRPC},
+	{"spectate_game", spectateGameRPC},
+	{"accept_auto_draw", acceptAutoDrawRPC},
+	{"set_player_meta", setPlayerMetaRPC},
+	{"get_random_open_game", getRandomOpenGameRPC},
+	{"get_game_summary", getGameSummaryRPC},
+	{"ack_move", ackMoveRPC},
+	{"seed_games", seedGamesRPC},
+	{"get_turn", getTurnRPC},
+	{"register_preset", registerPresetRPC},
+	{"get_board_hash", getBoardHashRPC},
+	{"leave_spectate", leaveSpectateRPC},
+	{"get_last_move", getLastMoveRPC},
+	{"get_winning_moves", getWinningMovesRPC},
+	{"get_winning_cells", getWinningCellsRPC},
+}
+
+// returned in place of a panic so clients never see its details
+var errInternal = errors.New("internal error")
+
+// gRPC status codes Nakama passes through for runtime errors
+const (
+	codeInvalidArgument    = 3
+	codeNotFound           = 5
+	codePermissionDenied   = 7
+	codeFailedPrecondition = 9
+	codeInternal           = 13
+	codeUnauthenticated    = 16
+)
+
+// withRecover: wrap an RPC so a panic is logged and returned as "internal error" instead of escaping
+func withRecover(id string, fn rpcFunc) rpcFunc {
+	return func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (resp string, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.WithFields(map[string]interface{}{"rpc": id, "payload": payload}).Error("RPC panicked: %v\n%s", r, debug.Stack())
+				resp, err = "", errInternal
+			}
+		}()
+		return fn(ctx, logger, db, nk, payload)
+	}
+}
+
+// withStatusCodes: wrap an RPC so its errors carry a status code, for the "_v2" names
+func withStatusCodes(fn rpcFunc) rpcFunc {
+	return func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+		resp, err := fn(ctx, logger, db, nk, payload)
+		if err == nil {
+			return resp, nil
+		}
+		return "", runtime.NewError(err.Error(), statusCode(err))
+	}
+}
+
+// helper: status code for an RPC error.
+// Anything that isn't a known server-side condition is the client's fault.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, errGameNotFound):
+		return codeNotFound
+	case errors.Is(err, errAdminOnly), errors.Is(err, errSeatTaken):
+		return codePermissionDenied
+	case errors.Is(err, errGameFinished), errors.Is(err, errGameNotFinished):
+		return codeFailedPrecondition
+	case errors.Is(err, errInternal):
+		return codeInternal
+	case errors.Is(err, errAuthRequired):
+		return codeUnauthenticated
+	}
+	var rtErr *runtime.Error
+	if errors.As(err, &rtErr) {
+		return rtErr.Code
+	}
+	return codeInvalidArgument
+}
+
+// withErrorEnvelope: wrap an RPC so it never fails at the RPC level, errors come back as
+// {"ok":false,"error":"...","code":5} with the same codes as the "_v2" names. Used for every
+// name when TTT_ERRORS_AS_DATA is on.
+func withErrorEnvelope(fn rpcFunc) rpcFunc {
+	return func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+		resp, err := fn(ctx, logger, db, nk, payload)
+		if err == nil {
+			return resp, nil
+		}
+		b, _ := json.Marshal(map[string]interface{}{"ok": false, "error": err.Error(), "code": statusCode(err)})
+		return string(b), nil
+	}
+}
+
 func InitModule(
 	ctx context.Context,
 	logger runtime.Logger,
@@ -17,23 +132,31 @@ func InitModule(
 
 	// Simple log so we know the module loaded
 	logger.Info("Loading TicTacToe Module...")
+	loadConfig(ctx, logger)
+	registerArchive(logger, nk)
 
-	// Register RPCs. These must match the signature expected by Nakama:
-	// func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error)
-	if err := initializer.RegisterRpc("create_game", createGameRPC); err != nil {
-		logger.Error("Unable to register create_game: %v", err)
-		return err
-	}
-	if err := initializer.RegisterRpc("make_move", makeMoveRPC); err != nil {
-		logger.Error("Unable to register make_move: %v", err)
-		return err
-	}
-	if err := initializer.RegisterRpc("get_game", getGameRPC); err != nil {
-		logger.Error("Unable to register get_game: %v", err)
-		return err
+	// Register RPCs, each wrapped so a panicking handler can't take down request handling.
+	// Every RPC also gets a "_v2" name that returns errors with status codes; the original
+	// names keep their plain errors for existing clients.
+	names := make([]string, 0, 2*len(rpcs))
+	for _, r := range rpcs {
+		handler := withRecover(r.id, r.fn)
+		v2 := withStatusCodes(handler)
+		if errorsAsData {
+			handler, v2 = withErrorEnvelope(handler), withErrorEnvelope(v2)
+		}
+		if err := initializer.RegisterRpc(r.id, handler); err != nil {
+			logger.Error("Unable to register %s: %v", r.id, err)
+			return err
+		}
+		if err := initializer.RegisterRpc(r.id+"_v2", v2); err != nil {
+			logger.Error("Unable to register %s_v2: %v", r.id, err)
+			return err
+		}
+		names = append(names, r.id, r.id+"_v2")
 	}
 
-	logger.Info("TicTacToe RPCs registered: create_game, make_move, get_game")
+	logger.Info("TicTacToe RPCs registered: %s", strings.Join(names, ", "))
 	return nil
 }
 
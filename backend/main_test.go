@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+func TestRecoverTurnsPanicIntoInternalError(t *testing.T) {
+	panicking := func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+		var board []rune
+		return string(board[9]), nil
+	}
+	logger := newTestLogger()
+
+	resp, err := withRecover("boom", panicking)(context.Background(), logger, nil, nil, `{}`)
+	if err != errInternal || resp != "" {
+		t.Fatalf("got (%q, %v), want errInternal", resp, err)
+	}
+	var logged bool
+	for _, line := range *logger.lines {
+		if line.level == "error" && line.fields["rpc"] == "boom" {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Fatalf("panic wasn't logged with the rpc id: %v", *logger.lines)
+	}
+}
+
+func TestV2ErrorsCarryStatusCodes(t *testing.T) {
+	setupTest(t)
+	setValue(t, &adminIDs, map[string]bool{})
+	finished := createGame(t, `{}`)
+	playCells(t, finished, 0, 3, 1, 4, 2)
+
+	cases := []struct {
+		ctx     context.Context
+		fn      rpcFunc
+		payload string
+		code    int
+	}{
+		{context.Background(), getGameRPC, `{"game_id":"g-missing"}`, codeNotFound},
+		{context.Background(), makeMoveRPC, gameRequest(finished, `"cell":8`), codeFailedPrecondition},
+		{context.Background(), makeMoveRPC, `{"cell":4}`, codeInvalidArgument},
+		{userContext("player", "s1"), getStatisticsRPC, `{}`, codePermissionDenied},
+	}
+	for _, c := range cases {
+		_, err := withStatusCodes(c.fn)(c.ctx, newTestLogger(), nil, nil, c.payload)
+		rtErr, ok := err.(*runtime.Error)
+		if !ok || rtErr.Code != c.code {
+			t.Errorf("%s: err = %#v, want code %d", c.payload, err, c.code)
+		}
+	}
+
+	// successful calls pass straight through
+	if resp, err := withStatusCodes(getServerTimeRPC)(context.Background(), newTestLogger(), nil, nil, ``); err != nil || resp == "" {
+		t.Fatalf("get_server_time: %q, %v", resp, err)
+	}
+}
+
+func TestErrorEnvelopeReturnsErrorsAsData(t *testing.T) {
+	setupTest(t)
+	for _, fn := range []rpcFunc{withErrorEnvelope(getGameRPC), withErrorEnvelope(withStatusCodes(getGameRPC))} {
+		resp, err := callRPC(t, fn, `{"game_id":"g-missing"}`)
+		if err != nil {
+			t.Fatalf("envelope failed the call: %v", err)
+		}
+		if resp["ok"] != false || resp["error"] != "game not found" || resp["code"] != float64(codeNotFound) {
+			t.Fatalf("resp = %v", resp)
+		}
+	}
+
+	// successful calls pass straight through
+	gid := createGame(t, `{}`)
+	resp, err := callRPC(t, withErrorEnvelope(getGameRPC), gameRequest(gid))
+	if err != nil || resp["ok"] != true {
+		t.Fatalf("get_game: %v, %v", resp, err)
+	}
+}
+
+// fakeInitializer keeps the RPCs InitModule registers. Anything else panics on the nil embedded interface.
+type fakeInitializer struct {
+	runtime.Initializer
+	rpcs map[string]rpcFunc
+}
+
+func (i *fakeInitializer) RegisterRpc(id string, fn func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error)) error {
+	i.rpcs[id] = fn
+	return nil
+}
+
+func TestV1AndV2NamesDifferOnlyInErrors(t *testing.T) {
+	setupTest(t)
+	reg := &fakeInitializer{rpcs: map[string]rpcFunc{}}
+	if err := InitModule(context.Background(), newTestLogger(), nil, nil, reg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same failing call: v1 keeps the plain error, v2 adds the status code
+	payload := `{"game_id":"g-missing"}`
+	if _, err := callRPC(t, reg.rpcs["get_game"], payload); err != errGameNotFound {
+		t.Fatalf("get_game: err = %#v, want the plain error", err)
+	}
+	_, err := callRPC(t, reg.rpcs["get_game_v2"], payload)
+	if rtErr, ok := err.(*runtime.Error); !ok || rtErr.Code != codeNotFound || rtErr.Message != errGameNotFound.Error() {
+		t.Fatalf("get_game_v2: err = %#v, want code %d", err, codeNotFound)
+	}
+
+	// and the same successful call gets the same response from both
+	gid := createGame(t, `{}`)
+	v1, _ := callRPC(t, reg.rpcs["get_game"], gameRequest(gid))
+	v2, _ := callRPC(t, reg.rpcs["get_game_v2"], gameRequest(gid))
+	if v1["game"].(map[string]interface{})["game_id"] != gid || v2["game"].(map[string]interface{})["game_id"] != gid {
+		t.Fatalf("v1 %v, v2 %v", v1, v2)
+	}
+	for _, r := range rpcs {
+		if reg.rpcs[r.id] == nil || reg.rpcs[r.id+"_v2"] == nil {
+			t.Errorf("%s isn't registered under both names", r.id)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Display metadata players attach to their seat. It's purely cosmetic and never affects
+// play, but it bumps the game version so clients polling with if_version see it.
+
+// PlayerMeta is how a UI should show one seat
+type PlayerMeta struct {
+	DisplayName string `json:"display_name,omitempty"`
+	Color       string `json:"color,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// longest accepted values for each metadata field, in characters
+const (
+	maxDisplayNameLen = 32
+	maxColorLen       = 32
+	maxAvatarURLLen   = 512
+)
+
+// setPlayerMetaRPC: set the display metadata of one seat, expects
+// {"game_id":"...","mark":"X","display_name":"...","color":"...","avatar_url":"https://..."}.
+// Each call replaces the seat's metadata; omitted fields are cleared.
+func setPlayerMetaRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req SetPlayerMetaRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	if !containsString(game.Marks, req.Mark) {
+		return "", errors.New("invalid mark")
+	}
+	if err := claimSeat(ctx, game, req.Mark); err != nil {
+		return "", err
+	}
+
+	if game.PlayerMeta == nil {
+		game.PlayerMeta = map[string]PlayerMeta{}
+	}
+	game.PlayerMeta[req.Mark] = PlayerMeta{
+		DisplayName: req.DisplayName,
+		Color:       req.Color,
+		AvatarURL:   req.AvatarURL,
+	}
+	game.Version++
+	broadcastGame(logger, nk, game)
+
+	resp := map[string]interface{}{
+		"ok":          true,
+		"player_meta": game.PlayerMeta,
+		"version":     game.Version,
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetPlayerMeta(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	mustRPC(t, setPlayerMetaRPC, gameRequest(gid, `"mark":"X"`, `"display_name":"Ann"`, `"color":"#ff0066"`, `"avatar_url":"https://example.com/a.png"`))
+	// a second call replaces the seat's metadata rather than merging
+	mustRPC(t, setPlayerMetaRPC, gameRequest(gid, `"mark":"X"`, `"display_name":"Ann B"`))
+
+	game := gameState(t, gid)
+	if meta := game.PlayerMeta["X"]; meta != (PlayerMeta{DisplayName: "Ann B"}) {
+		t.Fatalf("X meta = %+v", meta)
+	}
+	if game.Version != 2 {
+		t.Fatalf("version = %d, want each metadata change to bump it", game.Version)
+	}
+
+	// clients polling with if_version see the change
+	resp := mustRPC(t, getGameRPC, gameRequest(gid, `"if_version":2`))
+	if resp["not_modified"] != true {
+		t.Fatalf("unchanged game: %v", resp)
+	}
+	mustRPC(t, setPlayerMetaRPC, gameRequest(gid, `"mark":"O"`, `"display_name":"Bo"`))
+	resp = mustRPC(t, getGameRPC, gameRequest(gid, `"if_version":2`))
+	meta := resp["game"].(map[string]interface{})["player_meta"].(map[string]interface{})
+	if meta["O"].(map[string]interface{})["display_name"] != "Bo" {
+		t.Fatalf("get_game after the change = %v", resp)
+	}
+	diff := mustRPC(t, getGameDiffRPC, gameRequest(gid, `"known_version":2`))
+	if diff["changed"] != true || diff["full"] != true {
+		t.Fatalf("diff = %v", diff)
+	}
+}
+
+func TestSetPlayerMetaValidation(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	for _, c := range []struct{ field, err string }{
+		{`"mark":"Z"`, "invalid mark"},
+		{`"mark":"X","display_name":"` + strings.Repeat("é", 33) + `"`, "display_name longer than 32 characters"},
+		{`"mark":"X","avatar_url":"javascript:alert(1)"`, "avatar_url must be an http(s) url"},
+	} {
+		if _, err := callRPC(t, setPlayerMetaRPC, gameRequest(gid, c.field)); err == nil || err.Error() != c.err {
+			t.Errorf("%s: err = %v, want %s", c.field, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"strconv"
+	"strings"
+)
+
+// Move notation is the played cells in order separated by commas, e.g. "4,0,8,2".
+// Games that another mark started have it first with a colon, e.g. "O:4,0,8,2".
+// On import each cell may carry an annotation after the index ("4!", "0?", "8X"),
+// which is ignored.
+
+// helper: notation for a game's moves
+func exportNotation(game *Game) string {
+	cells := make([]string, len(game.History))
+	for i, m := range game.History {
+		cells[i] = strconv.Itoa(m.Cell)
+	}
+	notation := strings.Join(cells, ",")
+	if game.First != game.Marks[0] {
+		notation = game.First + ":" + notation
+	}
+	return notation
+}
+
+// helper: parse notation into the starting mark ("" when it doesn't say) and cell indices,
+// dropping annotations
+func parseNotation(notation string) (string, []int, error) {
+	first := ""
+	if i := strings.Index(notation, ":"); i >= 0 {
+		first = strings.TrimSpace(notation[:i])
+		if !containsString(allMarks, first) {
+			return "", nil, fmt.Errorf("invalid starting mark %q", first)
+		}
+		notation = notation[i+1:]
+	}
+	notation = strings.TrimSpace(notation)
+	if notation == "" {
+		return first, []int{}, nil
+	}
+	parts := strings.Split(notation, ",")
+	cells := make([]int, 0, len(parts))
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		// the index is the leading digits, anything after is annotation
+		end := 0
+		for end < len(p) && p[end] >= '0' && p[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			return "", nil, fmt.Errorf("move %d: invalid cell %q", i+1, p)
+		}
+		cell, _ := strconv.Atoi(p[:end])
+		cells = append(cells, cell)
+	}
+	return first, cells, nil
+}
+
+// exportNotationRPC: return a game's moves in notation, expects {"game_id":"..."}.
+// The starting mark and, for first:"random", the seed it was drawn from come back alongside.
+func exportNotationRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	game, err := lockGameOrArchive(ctx, logger, nk, req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+
+	resp := map[string]interface{}{
+		"ok":       true,
+		"game_id":  game.ID,
+		"notation": exportNotation(game),
+		"first":    game.First,
+	}
+	if game.Seed != nil {
+		resp["seed"] = *game.Seed
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// importNotationRPC: build a new game by replaying notation, expects {"notation":"4,0,8"}
+// plus any create_game options. Every move must be legal. A starting mark in the notation
+// is used as "first", and must agree with any "first" in the payload. The moves are replayed
+// at once, so ranked timing and ack_moves only apply from where the notation leaves off.
+func importNotationRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req ImportNotationRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+	first, cells, err := parseNotation(*req.Notation)
+	if err != nil {
+		return "", err
+	}
+	if first != "" && first != req.First {
+		if req.First != "" {
+			return "", fmt.Errorf("notation starts with %s but first is %s", first, req.First)
+		}
+		req.First = first
+		if err := req.CreateGameRequest.validate(); err != nil {
+			return "", err
+		}
+	}
+
+	game, err := buildGame(ctx, logger, nk, &req.CreateGameRequest)
+	if err != nil {
+		return "", err
+	}
+	game.replaying = true
+	for i, cell := range cells {
+		if err := applyMove(game, cell, callerID(ctx)); err != nil {
+			return "", fmt.Errorf("move %d: %v", i+1, err)
+		}
+	}
+	// the notation already has the bot's moves, it only replies to where it leaves off
+	playBotTurn(logger, game)
+	game.replaying = false
+
+	// build the response before the game is shared through the map
+	resp := map[string]interface{}{
+		"ok":   true,
+		"game": game,
+	}
+	b, _ := json.Marshal(resp)
+
+	storeGame(game)
+	// a game that was over by the last move only ends now that it exists
+	if game.Winner != "" {
+		game.mu.Lock()
+		runGameEndHooks(game)
+		game.mu.Unlock()
+	}
+	logDebug(logger, map[string]interface{}{"game_id": game.ID, "moves": len(cells)}, "game imported")
+	return string(b), nil
+}
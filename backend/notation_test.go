@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNotationRoundTrip(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	playCells(t, gid, 4, 0, 8, 2)
+
+	notation := mustRPC(t, exportNotationRPC, gameRequest(gid))["notation"].(string)
+	if notation != "4,0,8,2" {
+		t.Fatalf("notation = %q", notation)
+	}
+	imported := mustRPC(t, importNotationRPC, fmt.Sprintf(`{"notation":%q}`, notation))["game"].(map[string]interface{})
+	if imported["board"] != gameState(t, gid).Board || imported["turn"] != "X" {
+		t.Fatalf("imported game = %v", imported)
+	}
+}
+
+func TestParseNotationDropsAnnotations(t *testing.T) {
+	first, cells, err := parseNotation(" 4!, 0?,8X ")
+	if err != nil || first != "" || fmt.Sprint(cells) != "[4 0 8]" {
+		t.Fatalf("first %q cells %v, err %v", first, cells, err)
+	}
+	if _, _, err := parseNotation("4,,8"); err == nil || err.Error() != `move 2: invalid cell ""` {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestImportRejectsIllegalMove(t *testing.T) {
+	setupTest(t)
+	if _, err := callRPC(t, importNotationRPC, `{"notation":"4,4"}`); err == nil || err.Error() != "move 2: cell already occupied (B2 by X)" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestNotationRoundTripKeepsStarter(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{"first":"O"}`)
+	playCells(t, gid, 4, 0, 8)
+
+	resp := mustRPC(t, exportNotationRPC, gameRequest(gid))
+	if resp["notation"] != "O:4,0,8" || resp["first"] != "O" {
+		t.Fatalf("export = %v", resp)
+	}
+	imported := mustRPC(t, importNotationRPC, fmt.Sprintf(`{"notation":%q}`, resp["notation"]))["game"].(map[string]interface{})
+	if imported["board"] != "X---O---O" || imported["first"] != "O" || imported["turn"] != "X" {
+		t.Fatalf("imported game = %v", imported)
+	}
+
+	if _, err := callRPC(t, importNotationRPC, `{"notation":"O:4","first":"X"}`); err == nil || err.Error() != "notation starts with O but first is X" {
+		t.Fatalf("conflicting first: err = %v", err)
+	}
+	if _, err := callRPC(t, importNotationRPC, `{"notation":"Z:4"}`); err == nil || err.Error() != "invalid first" {
+		t.Fatalf("Z in a two-player game: err = %v", err)
+	}
+	if _, err := callRPC(t, importNotationRPC, `{"notation":"Q:4"}`); err == nil || err.Error() != `invalid starting mark "Q"` {
+		t.Fatalf("unknown mark: err = %v", err)
+	}
+}
+
+func TestRandomStarterFollowsSeed(t *testing.T) {
+	setupTest(t)
+	starters := map[string]bool{}
+	for seed := 0; seed < 20; seed++ {
+		options := fmt.Sprintf(`{"first":"random","seed":%d}`, seed)
+		a, b := gameState(t, createGame(t, options)), gameState(t, createGame(t, options))
+		if a.First != b.First || a.Turn != a.First || *a.Seed != int64(seed) {
+			t.Fatalf("seed %d: starters %s and %s, turn %s", seed, a.First, b.First, a.Turn)
+		}
+		starters[a.First] = true
+
+		resp := mustRPC(t, exportNotationRPC, gameRequest(a.ID))
+		if resp["seed"] != float64(seed) || resp["first"] != a.First {
+			t.Fatalf("export = %v", resp)
+		}
+	}
+	if len(starters) != 2 {
+		t.Fatalf("20 seeds only ever started %v", starters)
+	}
+}
+
+func TestImportReplaysTimedGames(t *testing.T) {
+	setupTest(t)
+	setValue(t, &minThinkMs, int64(1000))
+	setClock(t, 1000)
+
+	ranked := mustRPC(t, importNotationRPC, `{"notation":"4,0,8","ranked":true}`)["game"].(map[string]interface{})
+	if ranked["board"] != "O---X---X" {
+		t.Fatalf("ranked import = %v", ranked)
+	}
+	// the player to move acknowledges the last replayed move as usual
+	acked := mustRPC(t, importNotationRPC, `{"notation":"4,0,8","clock_seconds":60,"ack_moves":true}`)["game"].(map[string]interface{})
+	if acked["board"] != "O---X---X" || acked["awaiting_ack"] != true {
+		t.Fatalf("ack_moves import = %v", acked)
+	}
+	if _, err := callRPC(t, makeMoveRPC, gameRequest(acked["game_id"].(string), `"cell":2`)); err == nil || err.Error() != "acknowledge the last move first" {
+		t.Fatalf("move before ack: err = %v", err)
+	}
+
+	if _, err := callRPC(t, importNotationRPC, `{"notation":"4","idempotency_key":"k1"}`); err == nil || err.Error() != "idempotency_key is only for create_game" {
+		t.Fatalf("idempotency_key: err = %v", err)
+	}
+}
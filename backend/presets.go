@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Board presets are named starting positions for teaching setups. Admins register them
+// in Nakama storage under the system user, and create_game can start from one by name.
+
+// storage collection holding the presets, keyed by preset name
+const presetCollection = "ttt_presets"
+
+// Preset is the stored value of one preset
+type Preset struct {
+	Board string `json:"board"`
+}
+
+// helper: read a preset from storage, error if it isn't registered
+func loadPreset(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, name string) (*Preset, error) {
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{{Collection: presetCollection, Key: name}})
+	if err != nil {
+		logger.WithField("preset", name).Error("Unable to read preset: %v", err)
+		return nil, errInternal
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("unknown preset %q", name)
+	}
+	var preset Preset
+	if err := json.Unmarshal([]byte(objects[0].Value), &preset); err != nil || !validBoard(preset.Board) {
+		logger.WithField("preset", name).Error("Stored preset is corrupt: %v", err)
+		return nil, errInternal
+	}
+	return &preset, nil
+}
+
+// applyPreset: start a freshly built game from a preset board. The marks on the board
+// must suit the game's players and the position mustn't be decided already.
+func applyPreset(game *Game, preset *Preset) error {
+	counts := map[string]int{}
+	placed := 0
+	for _, c := range preset.Board {
+		if c == '-' {
+			continue
+		}
+		if !containsString(game.Marks, string(c)) {
+			return fmt.Errorf("preset uses mark %c, not in this game", c)
+		}
+		counts[string(c)]++
+		placed++
+	}
+	// players move in seating order, so earlier seats have at most one mark more than later ones
+	for i, m := range game.Marks {
+		want := placed / len(game.Marks)
+		if i < placed%len(game.Marks) {
+			want++
+		}
+		if counts[m] != want {
+			return errors.New("preset move counts don't fit the turn order")
+		}
+	}
+
+	game.Board = preset.Board
+	if settleResult(game) {
+		return errors.New("preset position is already finished")
+	}
+	game.Turn = game.Marks[placed%len(game.Marks)]
+	game.First = game.Turn
+	return nil
+}
+
+// registerPresetRPC: store a named board preset, expects {"name":"fork-trap","board":"X---O---X"}.
+// Registering an existing name replaces it.
+func registerPresetRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if !isAdmin(ctx) {
+		return "", errAdminOnly
+	}
+
+	var req RegisterPresetRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+
+	value, _ := json.Marshal(Preset{Board: req.Board})
+	write := &runtime.StorageWrite{
+		Collection:      presetCollection,
+		Key:             req.Name,
+		Value:           string(value),
+		PermissionRead:  0, // only the server reads presets
+		PermissionWrite: 0,
+	}
+	if _, err := nk.StorageWrite(ctx, []*runtime.StorageWrite{write}); err != nil {
+		logger.WithField("preset", req.Name).Error("Unable to store preset: %v", err)
+		return "", errInternal
+	}
+	logger.WithFields(map[string]interface{}{"preset": req.Name, "board": req.Board, "actor": callerID(ctx)}).Info("admin registered preset")
+
+	resp := map[string]interface{}{
+		"ok":     true,
+		"name":   req.Name,
+		"preset": Preset{Board: req.Board},
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
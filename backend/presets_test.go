@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCreateGameFromPreset(t *testing.T) {
+	setupTest(t)
+	nk := &fakeNK{}
+	ctx := context.Background()
+	if _, err := callRPCWith(t, ctx, nk, registerPresetRPC, `{"name":"fork-trap","board":"X---O---X"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := callRPCWith(t, ctx, nk, createGameRPC, `{"preset":"fork-trap"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game := gameState(t, resp["game_id"].(string))
+	if game.Board != "X---O---X" || game.Turn != "O" || game.First != "O" {
+		t.Fatalf("board %s turn %s first %s", game.Board, game.Turn, game.First)
+	}
+
+	if _, err := callRPCWith(t, ctx, nk, createGameRPC, `{"preset":"missing"}`); err == nil || err.Error() != `unknown preset "missing"` {
+		t.Fatalf("unknown preset: err = %v", err)
+	}
+}
+
+func TestPresetMustFitTheGame(t *testing.T) {
+	setupTest(t)
+	nk := &fakeNK{}
+	ctx := context.Background()
+	for name, board := range map[string]string{"uneven": "XX-------", "three": "XOZ------", "won": "XXXOO----"} {
+		if _, err := callRPCWith(t, ctx, nk, registerPresetRPC, `{"name":"`+name+`","board":"`+board+`"}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{
+		"uneven": "preset move counts don't fit the turn order",
+		"three":  "preset uses mark Z, not in this game",
+		"won":    "preset position is already finished",
+	} {
+		if _, err := callRPCWith(t, ctx, nk, createGameRPC, `{"preset":"`+name+`"}`); err == nil || err.Error() != want {
+			t.Errorf("%s: err = %v, want %s", name, err, want)
+		}
+	}
+}
+
+func TestPresetGamesPassTheInvariant(t *testing.T) {
+	setupTest(t)
+	setValue(t, &checkInvariants, true)
+	nk := &fakeNK{}
+	ctx := context.Background()
+	callRPCWith(t, ctx, nk, registerPresetRPC, `{"name":"fork-trap","board":"X---O---X"}`)
+	resp, err := callRPCWith(t, ctx, nk, createGameRPC, `{"preset":"fork-trap"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playCells(t, resp["game_id"].(string), 2, 6)
+}
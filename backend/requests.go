@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Typed RPC payloads. Every request is decoded strictly by decodeRequest, so unknown
+// fields, wrong types and missing required fields all produce the same style of error.
+
+// validator is implemented by every request type to check required fields and values
+type validator interface {
+	validate() error
+}
+
+// CellIndex accepts a JSON number or a numeric string, e.g. 4 or "4"
+type CellIndex int
+
+func (c *CellIndex) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return errors.New("invalid cell index")
+	}
+	switch x := v.(type) {
+	case float64:
+		if x != math.Trunc(x) {
+			return errors.New("invalid cell index")
+		}
+		*c = CellIndex(x)
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(x))
+		if err != nil {
+			return errors.New("invalid cell index")
+		}
+		*c = CellIndex(n)
+	default:
+		return errors.New("invalid cell index")
+	}
+	return nil
+}
+
+// CreateGameRequest: options for create_game, all optional
+type CreateGameRequest struct {
+	NoDraw         bool `json:"no_draw"`
+	Players        *int `json:"players"`
+	RequireConfirm bool `json:"require_confirm"`
+	ClockSeconds   int  `json:"clock_seconds"`
+	AckMoves       bool `json:"ack_moves"`
+	Ranked         bool `json:"ranked"`
+
+	WinLines [][]int `json:"win_lines"` // replaces the standard rows, columns and diagonals
+
+	First string `json:"first"` // starting mark or "random", X by default
+	Seed  *int64 `json:"seed"`  // seed for first:"random", derived from the game id by default
+
+	Preset string `json:"preset"` // name of a registered starting position
+	VsBot  bool   `json:"vs_bot"` // the practice bot plays O
+
+	BotDifficulty string `json:"bot_difficulty"` // with vs_bot: "best" (default) or "mirror"
+
+	MaxDistance int `json:"max_distance"` // anti-stalling: each move must be this close to a mark
+
+	BindSessions bool `json:"bind_sessions"` // lock each mark to the session that first moves it
+
+	IdempotencyKey string `json:"idempotency_key"` // retries with the same key return the first game
+}
+
+func (r *CreateGameRequest) validate() error {
+	if r.Players != nil && (*r.Players < 2 || *r.Players > len(allMarks)) {
+		return fmt.Errorf("players must be 2-%d", len(allMarks))
+	}
+	if r.ClockSeconds < 0 {
+		return errors.New("invalid clock_seconds")
+	}
+	if r.ClockSeconds > 0 && r.Players != nil && *r.Players != 2 {
+		return errors.New("clock_seconds needs a two-player game")
+	}
+	if r.AckMoves && r.ClockSeconds == 0 {
+		return errors.New("ack_moves needs clock_seconds")
+	}
+	players := 2
+	if r.Players != nil {
+		players = *r.Players
+	}
+	if r.First != "" && r.First != "random" && !containsString(allMarks[:players], r.First) {
+		return errors.New("invalid first")
+	}
+	if r.Seed != nil && r.First != "random" {
+		return errors.New("seed needs first \"random\"")
+	}
+	if r.MaxDistance < 0 || r.MaxDistance >= boardSize {
+		return fmt.Errorf("max_distance must be 0-%d", boardSize-1)
+	}
+	if len(r.IdempotencyKey) > maxIdempotencyKeyLen {
+		return fmt.Errorf("idempotency_key must be at most %d characters", maxIdempotencyKeyLen)
+	}
+	if r.VsBot && players != 2 {
+		return errors.New("vs_bot needs a two-player game")
+	}
+	if r.VsBot && (r.Ranked || r.AckMoves) {
+		// the bot replies instantly and never acknowledges moves
+		return errors.New("vs_bot can't be combined with ranked or ack_moves")
+	}
+	switch r.BotDifficulty {
+	case "", "best", "mirror":
+	default:
+		return errors.New(`bot_difficulty must be "best" or "mirror"`)
+	}
+	if r.BotDifficulty != "" && !r.VsBot {
+		return errors.New("bot_difficulty needs vs_bot")
+	}
+	if r.Preset != "" && r.First != "" {
+		// the preset position decides who is to move
+		return errors.New("preset can't be combined with first")
+	}
+	if r.WinLines != nil {
+		return validateWinLines(r.WinLines)
+	}
+	return nil
+}
+
+// helper: custom win lines need in-range cells, at least two per line and no repeats
+func validateWinLines(lines [][]int) error {
+	if len(lines) == 0 || len(lines) > maxWinLines {
+		return fmt.Errorf("win_lines must have 1-%d lines", maxWinLines)
+	}
+	for _, line := range lines {
+		if len(line) < 2 {
+			return errors.New("win line needs at least 2 cells")
+		}
+		seen := map[int]bool{}
+		for _, cell := range line {
+			if err := checkCellRange(cell, boardCells); err != nil {
+				return err
+			}
+			if seen[cell] {
+				return errors.New("win line repeats a cell")
+			}
+			seen[cell] = true
+		}
+	}
+	return nil
+}
+
+// GameRequest: any request that only names a game
+type GameRequest struct {
+	GameID string `json:"game_id"`
+}
+
+func (r *GameRequest) validate() error {
+	if r.GameID == "" {
+		return errors.New("missing game_id")
+	}
+	return nil
+}
+
+// GetGameRequest: payload for get_game
+type GetGameRequest struct {
+	GameID        string `json:"game_id"`
+	Format        string `json:"format"`
+	BoardFormat   string `json:"board_format"`
+	Orientation   string `json:"orientation"`
+	IncludeEvents bool   `json:"include_events"`
+	IncludeEval   bool   `json:"include_eval"`
+	IfVersion     *int   `json:"if_version"` // skip the payload if the game hasn't changed since this version
+}
+
+func (r *GetGameRequest) validate() error {
+	if r.GameID == "" {
+		return errors.New("missing game_id")
+	}
+	if err := validateFormat(r.Format); err != nil {
+		return err
+	}
+	if err := validateOrientation(r.Orientation); err != nil {
+		return err
+	}
+	if r.IfVersion != nil && *r.IfVersion < 0 {
+		return errors.New("invalid if_version")
+	}
+	return validateBoardFormat(r.BoardFormat)
+}
+
+// MakeMoveRequest: payload for make_move
+type MakeMoveRequest struct {
+	GameID  string     `json:"game_id"`
+	Cell    *CellIndex `json:"cell"`
+	Mark    string     `json:"mark"` // optional, must match the mark the server expects
+	Confirm bool       `json:"confirm"`
+	Format  string     `json:"format"`
+
+	BoardFormat string `json:"board_format"`
+	Orientation string `json:"orientation"` // cell and every cell in the response are in this orientation
+}
+
+func (r *MakeMoveRequest) validate() error {
+	if r.GameID == "" {
+		return errors.New("missing game_id")
+	}
+	if r.Cell == nil {
+		return errors.New("missing cell")
+	}
+	if err := validateFormat(r.Format); err != nil {
+		return err
+	}
+	if err := validateOrientation(r.Orientation); err != nil {
+		return err
+	}
+	return validateBoardFormat(r.BoardFormat)
+}
+
+// PlayMovesRequest: payload for play_moves
+type PlayMovesRequest struct {
+	GameID string      `json:"game_id"`
+	Cells  []CellIndex `json:"cells"`
+
+	Confirm bool `json:"confirm"` // confirms a game-ending move in require_confirm games
+}
+
+func (r *PlayMovesRequest) validate() error {
+	if r.GameID == "" {
+		return errors.New("missing game_id")
+	}
+	if r.Cells == nil {
+		return errors.New("missing cells")
+	}
+	return nil
+}
+
+// GameDiffRequest: payload for get_game_diff
+type GameDiffRequest struct {
+	GameID       string `json:"game_id"`
+	KnownVersion *int   `json:"known_version"`
+}
+
+func (r *GameDiffRequest) validate() error {
+	if r.GameID == "" {
+		return errors.New("missing game_id")
+	}
+	if r.KnownVersion == nil {
+		return errors.New("missing known_version")
+	}
+	return nil
+}
+
+// AdminSetCellRequest: payload for admin_set_cell, an empty mark or "-" clears the cell
+type AdminSetCellRequest struct {
+	GameID string     `json:"game_id"`
+	Cell   *CellIndex `json:"cell"`
+	Mark   string     `json:"mark"`
+}
+
+func (r *AdminSetCellRequest) validate() error {
+	if r.GameID == "" {
+		return errors.New("missing game_id")
+	}
+	if r.Cell == nil {
+		return errors.New("missing cell")
+	}
+	return nil
+}
+
+// RegisterPresetRequest: payload for register_preset
+type RegisterPresetRequest struct {
+	Name  string `json:"name"`
+	Board string `json:"board"`
+}
+
+func (r *RegisterPresetRequest) validate() error {
+	if r.Name == "" || len(r.Name) > 32 {
+		return errors.New("name must be 1-32 characters")
+	}
+	for _, c := range r.Name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return errors.New("name may only use a-z, 0-9, - and _")
+		}
+	}
+	if !validBoard(r.Board) {
+		return fmt.Errorf("board must be %d cells", boardCells)
+	}
+	for _, c := range r.Board {
+		if c != '-' && !containsString(allMarks, string(c)) {
+			return fmt.Errorf("invalid board cell %q", c)
+		}
+	}
+	return nil
+}
+
+// SeedGamesRequest: payload for seed_games, how many games to create in each state
+type SeedGamesRequest struct {
+	Counts map[string]int `json:"counts"`
+}
+
+func (r *SeedGamesRequest) validate() error {
+	total := 0
+	for state, n := range r.Counts {
+		if !containsString(seedStates, state) {
+			return fmt.Errorf("invalid state %q", state)
+		}
+		if n < 0 || n > maxSeedGames {
+			return fmt.Errorf("count for %q must be 0-%d", state, maxSeedGames)
+		}
+		total += n
+	}
+	if total == 0 || total > maxSeedGames {
+		return fmt.Errorf("counts must add up to 1-%d games", maxSeedGames)
+	}
+	return nil
+}
+
+// AcceptAutoDrawRequest: payload for accept_auto_draw
+type AcceptAutoDrawRequest struct {
+	GameID string `json:"game_id"`
+	Mark   string `json:"mark"`
+}
+
+func (r *AcceptAutoDrawRequest) validate() error {
+	if r.GameID == "" {
+		return errors.New("missing game_id")
+	}
+	if r.Mark == "" {
+		return errors.New("missing mark")
+	}
+	return nil
+}
+
+// SetPlayerMetaRequest: payload for set_player_meta
+type SetPlayerMetaRequest struct {
+	GameID      string `json:"game_id"`
+	Mark        string `json:"mark"`
+	DisplayName string `json:"display_name"`
+	Color       string `json:"color"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+func (r *SetPlayerMetaRequest) validate() error {
+	if r.GameID == "" {
+		return errors.New("missing game_id")
+	}
+	if r.Mark == "" {
+		return errors.New("missing mark")
+	}
+	if utf8.RuneCountInString(r.DisplayName) > maxDisplayNameLen {
+		return fmt.Errorf("display_name longer than %d characters", maxDisplayNameLen)
+	}
+	if utf8.RuneCountInString(r.Color) > maxColorLen {
+		return fmt.Errorf("color longer than %d characters", maxColorLen)
+	}
+	if r.AvatarURL != "" {
+		if len(r.AvatarURL) > maxAvatarURLLen {
+			return fmt.Errorf("avatar_url longer than %d characters", maxAvatarURLLen)
+		}
+		u, err := url.Parse(r.AvatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("avatar_url must be an http(s) url")
+		}
+	}
+	return nil
+}
+
+// AckMoveRequest: payload for ack_move
+type AckMoveRequest struct {
+	GameID string `json:"game_id"`
+	Mark   string `json:"mark"`
+}
+
+func (r *AckMoveRequest) validate() error {
+	if r.GameID == "" {
+		return errors.New("missing game_id")
+	}
+	if r.Mark == "" {
+		return errors.New("missing mark")
+	}
+	return nil
+}
+
+// ImportNotationRequest: payload for import_notation, takes the create_game options too
+type ImportNotationRequest struct {
+	CreateGameRequest
+	Notation *string `json:"notation"`
+}
+
+func (r *ImportNotationRequest) validate() error {
+	if r.Notation == nil {
+		return errors.New("missing notation")
+	}
+	if r.IdempotencyKey != "" {
+		return errors.New("idempotency_key is only for create_game")
+	}
+	return r.CreateGameRequest.validate()
+}
+
+// helper: response format requested in the payload, "" and "verbose" are the default
+func validateFormat(format string) error {
+	switch format {
+	case "", "verbose", "compact":
+		return nil
+	}
+	return errors.New("invalid format")
+}
+
+// helper: board orientation requested in the payload, "" and "normal" are the default
+func validateOrientation(orientation string) error {
+	switch orientation {
+	case "", "normal", "rotate90", "flip":
+		return nil
+	}
+	return errors.New("invalid orientation")
+}
+
+// helper: board format requested in the payload, "" and "flat" are the default
+func validateBoardFormat(boardFormat string) error {
+	switch boardFormat {
+	case "", "flat", "grid":
+		return nil
+	}
+	return errors.New("invalid board_format")
+}
+
+// decodeRequest: strictly decode an RPC payload into req and validate it.
+// An empty payload decodes as {} so optional-only requests accept it.
+func decodeRequest(payload string, req validator) error {
+	if strings.TrimSpace(payload) == "" {
+		payload = "{}"
+	}
+	dec := json.NewDecoder(strings.NewReader(payload))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil {
+		return decodeError(err)
+	}
+	return req.validate()
+}
+
+// helper: turn encoding/json errors into short client-facing messages
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return errors.New("invalid payload JSON")
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return errors.New("invalid payload JSON")
+		}
+		return fmt.Errorf("invalid %s", typeErr.Field)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Errorf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	case strings.HasPrefix(err.Error(), "json: "), strings.Contains(err.Error(), "EOF"):
+		return errors.New("invalid payload JSON")
+	}
+	// errors from custom unmarshalers such as CellIndex are already client-facing
+	return err
+}
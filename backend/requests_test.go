@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestDecodeMakeMoveRequest(t *testing.T) {
+	cases := []struct {
+		payload string
+		err     string
+		cell    int
+	}{
+		{`{"game_id":"g-1","cell":4}`, "", 4},
+		{`{"game_id":"g-1","cell":" 7 "}`, "", 7},
+		{`{"game_id":"g-1","cell":4.5}`, "invalid cell index", 0},
+		{`{"game_id":"g-1","cell":true}`, "invalid cell index", 0},
+		{`{"game_id":"g-1"}`, "missing cell", 0},
+		{`{"cell":4}`, "missing game_id", 0},
+		{`{"game_id":"g-1","cell":4,"cel":5}`, `unknown field "cel"`, 0},
+		{`{"game_id":7,"cell":4}`, "invalid game_id", 0},
+		{`{"game_id":"g-1",`, "invalid payload JSON", 0},
+		{`{"game_id":"g-1","cell":4,"format":"tiny"}`, "invalid format", 0},
+	}
+	for _, c := range cases {
+		var req MakeMoveRequest
+		err := decodeRequest(c.payload, &req)
+		if c.err == "" {
+			if err != nil || int(*req.Cell) != c.cell {
+				t.Errorf("%s: cell %v, err %v", c.payload, req.Cell, err)
+			}
+		} else if err == nil || err.Error() != c.err {
+			t.Errorf("%s: err = %v, want %s", c.payload, err, c.err)
+		}
+	}
+}
+
+func TestEmptyPayloadDecodesAsEmptyObject(t *testing.T) {
+	var req CreateGameRequest
+	if err := decodeRequest("  ", &req); err != nil {
+		t.Fatalf("empty create_game payload: %v", err)
+	}
+	var move MakeMoveRequest
+	if err := decodeRequest("", &move); err == nil || err.Error() != "missing game_id" {
+		t.Fatalf("empty make_move payload: err = %v", err)
+	}
+}
+
+func TestDecodeEveryRequestType(t *testing.T) {
+	cases := []struct {
+		name      string
+		req       func() validator
+		valid     string
+		wrongType string // a known field with a JSON value of the wrong type
+		typeErr   string
+	}{
+		{"CreateGameRequest", func() validator { return &CreateGameRequest{} }, `{"players":2}`, `{"players":"two"}`, "invalid players"},
+		{"GameRequest", func() validator { return &GameRequest{} }, `{"game_id":"g-1"}`, `{"game_id":1}`, "invalid game_id"},
+		{"GetGameRequest", func() validator { return &GetGameRequest{} }, `{"game_id":"g-1","if_version":2}`, `{"game_id":"g-1","if_version":"2"}`, "invalid if_version"},
+		{"MakeMoveRequest", func() validator { return &MakeMoveRequest{} }, `{"game_id":"g-1","cell":4}`, `{"game_id":"g-1","cell":4,"confirm":"yes"}`, "invalid confirm"},
+		{"PlayMovesRequest", func() validator { return &PlayMovesRequest{} }, `{"game_id":"g-1","cells":[4,0]}`, `{"game_id":"g-1","cells":4}`, "invalid cells"},
+		{"GameDiffRequest", func() validator { return &GameDiffRequest{} }, `{"game_id":"g-1","known_version":0}`, `{"game_id":"g-1","known_version":true}`, "invalid known_version"},
+		{"AdminSetCellRequest", func() validator { return &AdminSetCellRequest{} }, `{"game_id":"g-1","cell":4,"mark":"O"}`, `{"game_id":"g-1","cell":4,"mark":0}`, "invalid mark"},
+		{"RegisterPresetRequest", func() validator { return &RegisterPresetRequest{} }, `{"name":"p1","board":"X---O----"}`, `{"name":"p1","board":["X"]}`, "invalid board"},
+		{"SeedGamesRequest", func() validator { return &SeedGamesRequest{} }, `{"counts":{"waiting":1}}`, `{"counts":[1]}`, "invalid counts"},
+		{"AcceptAutoDrawRequest", func() validator { return &AcceptAutoDrawRequest{} }, `{"game_id":"g-1","mark":"X"}`, `{"game_id":"g-1","mark":["X"]}`, "invalid mark"},
+		{"SetPlayerMetaRequest", func() validator { return &SetPlayerMetaRequest{} }, `{"game_id":"g-1","mark":"X","color":"red"}`, `{"game_id":"g-1","mark":"X","color":1}`, "invalid color"},
+		{"AckMoveRequest", func() validator { return &AckMoveRequest{} }, `{"game_id":"g-1","mark":"O"}`, `{"game_id":"g-1","mark":false}`, "invalid mark"},
+		{"ImportNotationRequest", func() validator { return &ImportNotationRequest{} }, `{"notation":"4,0","no_draw":true}`, `{"notation":4}`, "invalid notation"},
+	}
+	for _, c := range cases {
+		if err := decodeRequest(c.valid, c.req()); err != nil {
+			t.Errorf("%s: valid payload %s: %v", c.name, c.valid, err)
+		}
+		checks := []struct{ payload, err string }{
+			{c.valid[:len(c.valid)-1], "invalid payload JSON"},
+			{`[]`, "invalid payload JSON"},
+			{c.valid[:len(c.valid)-1] + `,"bogus":1}`, `unknown field "bogus"`},
+			{c.wrongType, c.typeErr},
+		}
+		for _, check := range checks {
+			if err := decodeRequest(check.payload, c.req()); err == nil || err.Error() != check.err {
+				t.Errorf("%s: %s: err = %v, want %s", c.name, check.payload, err, check.err)
+			}
+		}
+	}
+}
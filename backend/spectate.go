@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"math/rand"
+	"sync"
+)
+
+// Spectators join a per-game Nakama stream and get every new state pushed to them
+// instead of polling get_game. The stream subject is the game id. Stream membership is per
+// session, but a user counts as one spectator however many sessions they watch from.
+
+// custom stream mode for game updates, clear of Nakama's built-in modes
+const spectateStreamMode uint8 = 100
+
+// spectatingMu guards spectating and is held for a whole spectate or leave call.
+// Lock order is spectatingMu before gamesMu.
+var (
+	spectatingMu sync.Mutex
+	spectating   = map[string]map[string]bool{} // game ids each user spectates, for maxSpectatePerUser
+)
+
+// helper: games userID spectates that are still in memory, forgetting the rest.
+// Caller must hold spectatingMu and not gamesMu.
+func spectatedGames(userID string) map[string]bool {
+	watched := spectating[userID]
+	gamesMu.RLock()
+	for gid := range watched {
+		if _, ok := games[gid]; !ok {
+			delete(watched, gid)
+		}
+	}
+	gamesMu.RUnlock()
+	return watched
+}
+
+// helper: caller's user and session, a stream needs both
+func callerSession(ctx context.Context) (string, string, error) {
+	userID, _ := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	sessionID, _ := ctx.Value(runtime.RUNTIME_CTX_SESSION_ID).(string)
+	if userID == "" || sessionID == "" {
+		return "", "", errors.New("spectating needs a user session")
+	}
+	return userID, sessionID, nil
+}
+
+// broadcastGame: push the game to its spectators, failures are logged but never fail the
+// move that caused them. Caller must hold game.mu.
+func broadcastGame(logger runtime.Logger, nk runtime.NakamaModule, game *Game) {
+	if len(game.Spectators) == 0 {
+		return
+	}
+	data, _ := json.Marshal(map[string]interface{}{"game": game})
+	if err := nk.StreamSend(spectateStreamMode, game.ID, "", "", string(data), nil, true); err != nil {
+		logger.WithField("game_id", game.ID).Warn("Unable to broadcast game update: %v", err)
+	}
+}
+
+// spectateGameRPC: join a game's stream to receive its updates, expects {"game_id":"..."}
+func spectateGameRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+	userID, sessionID, err := callerSession(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	spectatingMu.Lock()
+	defer spectatingMu.Unlock()
+	watched := spectatedGames(userID)
+
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+
+	// rejoining doesn't take another slot
+	if maxSpectatePerUser > 0 && len(watched) >= maxSpectatePerUser && !watched[game.ID] {
+		return "", fmt.Errorf("already spectating %d games, leave one first", len(watched))
+	}
+	if maxSpectators > 0 && len(game.Spectators) >= maxSpectators && !containsString(game.Spectators, userID) {
+		return "", errors.New("spectator limit reached")
+	}
+	if _, err := nk.StreamUserJoin(spectateStreamMode, game.ID, "", "", userID, sessionID, false, false, ""); err != nil {
+		logger.WithField("game_id", game.ID).Error("Unable to join spectate stream: %v", err)
+		return "", errors.New("unable to spectate")
+	}
+	// spectators are part of the game's state, so a new one is a new version
+	if !containsString(game.Spectators, userID) {
+		game.Spectators = append(game.Spectators, userID)
+		game.Version++
+	}
+	if game.spectatorSessions == nil {
+		game.spectatorSessions = map[string]map[string]bool{}
+	}
+	if game.spectatorSessions[userID] == nil {
+		game.spectatorSessions[userID] = map[string]bool{}
+	}
+	game.spectatorSessions[userID][sessionID] = true
+	if watched == nil {
+		watched = map[string]bool{}
+		spectating[userID] = watched
+	}
+	watched[game.ID] = true
+
+	resp := map[string]interface{}{
+		"ok":   true,
+		"game": game,
+		"stream": map[string]interface{}{
+			"mode":    spectateStreamMode,
+			"subject": game.ID,
+		},
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// leaveSpectateRPC: leave a game's stream from the calling session, expects {"game_id":"..."}.
+// The spectator slot is freed once the user has left from every session they joined from.
+func leaveSpectateRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req GameRequest
+	if err := decodeRequest(payload, &req); err != nil {
+		return "", err
+	}
+	userID, sessionID, err := callerSession(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	spectatingMu.Lock()
+	defer spectatingMu.Unlock()
+	game, err := lockGame(req.GameID)
+	if err != nil {
+		return "", err
+	}
+	defer game.mu.Unlock()
+	sessions := game.spectatorSessions[userID]
+	if !sessions[sessionID] {
+		return "", errors.New("not spectating")
+	}
+
+	if err := nk.StreamUserLeave(spectateStreamMode, game.ID, "", "", userID, sessionID); err != nil {
+		logger.WithField("game_id", game.ID).Error("Unable to leave spectate stream: %v", err)
+		return "", errors.New("unable to stop spectating")
+	}
+	delete(sessions, sessionID)
+	if len(sessions) == 0 {
+		delete(game.spectatorSessions, userID)
+		spectators := make([]string, 0, len(game.Spectators))
+		for _, id := range game.Spectators {
+			if id != userID {
+				spectators = append(spectators, id)
+			}
+		}
+		game.Spectators = spectators
+		game.Version++
+		delete(spectating[userID], game.ID)
+	}
+
+	resp := map[string]interface{}{
+		"ok":         true,
+		"spectators": len(game.Spectators),
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
+
+// getRandomOpenGameRPC: state of a randomly chosen in-progress game to watch, no payload needed.
+// The response has no "game" when nothing is being played. Every game is public in this server.
+func getRandomOpenGameRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	// reservoir sample so each open game is equally likely without collecting them first
+	var picked *Game
+	open := 0
+	gamesMu.RLock()
+	for _, game := range games {
+		game.mu.Lock()
+		if !game.removed && game.Winner == "" && validBoard(game.Board) {
+			open++
+			if rand.Intn(open) == 0 {
+				picked = game.Clone()
+			}
+		}
+		game.mu.Unlock()
+	}
+	gamesMu.RUnlock()
+
+	resp := map[string]interface{}{
+		"ok": true,
+	}
+	if picked != nil {
+		resp["game"] = picked
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
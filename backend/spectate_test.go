@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestSpectatorsGetEveryMove(t *testing.T) {
+	setupTest(t)
+	nk := &fakeNK{}
+	gid := createGame(t, `{}`)
+
+	// nothing is sent while nobody is watching
+	playCells(t, gid, 4)
+	if _, err := callRPCWith(t, userContext("watcher", "s1"), nk, spectateGameRPC, gameRequest(gid)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callRPCWith(t, context.Background(), nk, makeMoveRPC, gameRequest(gid, `"cell":0`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nk.sent) != 1 {
+		t.Fatalf("sent %d updates, want 1", len(nk.sent))
+	}
+	var update struct {
+		Game Game `json:"game"`
+	}
+	if err := json.Unmarshal([]byte(nk.sent[0]), &update); err != nil || update.Game.Board != "O---X----" || update.Game.Version != 3 {
+		t.Fatalf("update = %s (%v)", nk.sent[0], err)
+	}
+}
+
+func TestSpectateNeedsASession(t *testing.T) {
+	setupTest(t)
+	gid := createGame(t, `{}`)
+	if _, err := callRPCWith(t, userContext("watcher", ""), &fakeNK{}, spectateGameRPC, gameRequest(gid)); err == nil || err.Error() != "spectating needs a user session" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestRandomOpenGameSkipsFinishedGames(t *testing.T) {
+	setupTest(t)
+	if resp := mustRPC(t, getRandomOpenGameRPC, ``); resp["game"] != nil {
+		t.Fatalf("picked %v with no games", resp["game"])
+	}
+
+	playCells(t, createGame(t, `{}`), 0, 3, 1, 4, 2)
+	open := createGame(t, `{}`)
+	for i := 0; i < 20; i++ {
+		game := mustRPC(t, getRandomOpenGameRPC, ``)["game"].(map[string]interface{})
+		if game["game_id"] != open {
+			t.Fatalf("picked %v, want the open game %s", game["game_id"], open)
+		}
+	}
+}
+
+func TestSpectatorCap(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxSpectators, 2)
+	nk := &fakeNK{}
+	gid := createGame(t, `{}`)
+
+	for _, user := range []string{"u1", "u2", "u1"} {
+		if _, err := callRPCWith(t, userContext(user, "s-"+user), nk, spectateGameRPC, gameRequest(gid)); err != nil {
+			t.Fatalf("%s: %v", user, err)
+		}
+	}
+	if _, err := callRPCWith(t, userContext("u3", "s-u3"), nk, spectateGameRPC, gameRequest(gid)); err == nil || err.Error() != "spectator limit reached" {
+		t.Fatalf("third spectator: err = %v", err)
+	}
+	if spectators := gameState(t, gid).Spectators; len(spectators) != 2 {
+		t.Fatalf("spectators = %v", spectators)
+	}
+}
+
+func TestLeaveSpectateFreesTheSlot(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxSpectators, 1)
+	nk := &fakeNK{}
+	gid := createGame(t, `{}`)
+	u1, u2 := userContext("u1", "s1"), userContext("u2", "s2")
+
+	if _, err := callRPCWith(t, u2, nk, leaveSpectateRPC, gameRequest(gid)); err == nil || err.Error() != "not spectating" {
+		t.Fatalf("leaving unwatched game: err = %v", err)
+	}
+	callRPCWith(t, u1, nk, spectateGameRPC, gameRequest(gid))
+	resp, err := callRPCWith(t, u1, nk, leaveSpectateRPC, gameRequest(gid))
+	if err != nil || resp["spectators"] != 0.0 {
+		t.Fatalf("leave: %v, %v", resp, err)
+	}
+	if _, err := callRPCWith(t, u2, nk, spectateGameRPC, gameRequest(gid)); err != nil {
+		t.Fatalf("spectating the freed slot: %v", err)
+	}
+}
+
+func TestSpectatePerUserCap(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxSpectatePerUser, 2)
+	nk := &fakeNK{}
+	u1 := userContext("u1", "s1")
+	g1, g2, g3 := createGame(t, `{}`), createGame(t, `{}`), createGame(t, `{}`)
+
+	for _, gid := range []string{g1, g2, g1} {
+		if _, err := callRPCWith(t, u1, nk, spectateGameRPC, gameRequest(gid)); err != nil {
+			t.Fatalf("spectating %s: %v", gid, err)
+		}
+	}
+	if _, err := callRPCWith(t, u1, nk, spectateGameRPC, gameRequest(g3)); err == nil || err.Error() != "already spectating 2 games, leave one first" {
+		t.Fatalf("third game: err = %v", err)
+	}
+	// the cap is per user
+	if _, err := callRPCWith(t, userContext("u2", "s2"), nk, spectateGameRPC, gameRequest(g3)); err != nil {
+		t.Fatalf("another user: %v", err)
+	}
+
+	// leaving a game, or the game going away, frees a slot
+	callRPCWith(t, u1, nk, leaveSpectateRPC, gameRequest(g1))
+	if _, err := callRPCWith(t, u1, nk, spectateGameRPC, gameRequest(g3)); err != nil {
+		t.Fatalf("after leaving: %v", err)
+	}
+	gamesMu.Lock()
+	delete(games, g2)
+	gamesMu.Unlock()
+	if _, err := callRPCWith(t, u1, nk, spectateGameRPC, gameRequest(g1)); err != nil {
+		t.Fatalf("after a game was removed: %v", err)
+	}
+}
+
+func TestSpectatorsSeeForfeitsAndTimeouts(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxIllegalMoves, 2)
+	advance := setClock(t, 1000)
+	nk := &fakeNK{}
+	watcher := userContext("watcher", "s1")
+	lastUpdate := func() *Game {
+		t.Helper()
+		var update struct {
+			Game *Game `json:"game"`
+		}
+		if len(nk.sent) == 0 {
+			t.Fatal("nothing was sent")
+		}
+		json.Unmarshal([]byte(nk.sent[len(nk.sent)-1]), &update)
+		return update.Game
+	}
+
+	forfeited := createGame(t, `{}`)
+	callRPCWith(t, watcher, nk, spectateGameRPC, gameRequest(forfeited))
+	for i := 0; i < 2; i++ {
+		callRPCWith(t, context.Background(), nk, makeMoveRPC, gameRequest(forfeited, `"cell":9`, `"mark":"X"`))
+	}
+	if game := lastUpdate(); len(nk.sent) != 1 || game.Winner != "O" || game.WinKind != "forfeit" {
+		t.Fatalf("%d updates, last %+v", len(nk.sent), game)
+	}
+
+	// running out of time is pushed by the move that comes too late and by a poll that notices it
+	for _, late := range []struct {
+		fn    rpcFunc
+		extra []string
+	}{{makeMoveRPC, []string{`"cell":4`}}, {getGameRPC, nil}, {getTurnRPC, nil}} {
+		sent := len(nk.sent)
+		gid := createGame(t, `{"clock_seconds":5}`)
+		callRPCWith(t, watcher, nk, spectateGameRPC, gameRequest(gid))
+		advance(6000)
+		callRPCWith(t, context.Background(), nk, late.fn, gameRequest(gid, late.extra...))
+		if game := lastUpdate(); len(nk.sent) != sent+1 || game.ID != gid || game.WinKind != "timeout" {
+			t.Fatalf("%d new updates, last %+v", len(nk.sent)-sent, game)
+		}
+	}
+}
+
+func TestSpectatingFromTwoSessions(t *testing.T) {
+	setupTest(t)
+	setValue(t, &maxSpectatePerUser, 1)
+	nk := &fakeNK{}
+	gid, other := createGame(t, `{}`), createGame(t, `{}`)
+	phone, laptop := userContext("u1", "s1"), userContext("u1", "s2")
+
+	for _, ctx := range []context.Context{phone, laptop} {
+		if _, err := callRPCWith(t, ctx, nk, spectateGameRPC, gameRequest(gid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := callRPCWith(t, userContext("u1", "s3"), nk, leaveSpectateRPC, gameRequest(gid)); err == nil || err.Error() != "not spectating" {
+		t.Fatalf("leaving from a session that never joined: err = %v", err)
+	}
+
+	// still watching from the laptop, so still a spectator holding the slot
+	resp, err := callRPCWith(t, phone, nk, leaveSpectateRPC, gameRequest(gid))
+	if err != nil || resp["spectators"] != 1.0 {
+		t.Fatalf("leave from one session: %v, %v", resp, err)
+	}
+	if _, err := callRPCWith(t, phone, nk, spectateGameRPC, gameRequest(other)); err == nil {
+		t.Fatal("the slot was freed while a session still watches")
+	}
+
+	resp, err = callRPCWith(t, laptop, nk, leaveSpectateRPC, gameRequest(gid))
+	if err != nil || resp["spectators"] != 0.0 {
+		t.Fatalf("leave from the last session: %v, %v", resp, err)
+	}
+	if _, err := callRPCWith(t, phone, nk, spectateGameRPC, gameRequest(other)); err != nil {
+		t.Fatalf("after leaving from every session: %v", err)
+	}
+}
+
+func TestSpectatorChangesBumpTheVersion(t *testing.T) {
+	setupTest(t)
+	nk := &fakeNK{}
+	gid := createGame(t, `{}`)
+	watcher := userContext("watcher", "s1")
+
+	callRPCWith(t, watcher, nk, spectateGameRPC, gameRequest(gid))
+	resp := mustRPC(t, getGameRPC, gameRequest(gid, `"if_version":0`))
+	if spectators := resp["game"].(map[string]interface{})["spectators"].([]interface{}); len(spectators) != 1 {
+		t.Fatalf("get_game after a join = %v", resp)
+	}
+	// another session of a spectator changes nothing clients see
+	callRPCWith(t, userContext("watcher", "s2"), nk, spectateGameRPC, gameRequest(gid))
+	if version := gameState(t, gid).Version; version != 1 {
+		t.Fatalf("version = %d after a rejoin, want 1", version)
+	}
+
+	callRPCWith(t, userContext("watcher", "s2"), nk, leaveSpectateRPC, gameRequest(gid))
+	callRPCWith(t, watcher, nk, leaveSpectateRPC, gameRequest(gid))
+	resp = mustRPC(t, getGameRPC, gameRequest(gid, `"if_version":1`))
+	if resp["not_modified"] == true || len(resp["game"].(map[string]interface{})["spectators"].([]interface{})) != 0 {
+		t.Fatalf("get_game after leaving = %v", resp)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/heroiclabs/nakama-common/runtime"
+	"sync/atomic"
+)
+
+// movesServed counts every move played since the process started, for capacity planning.
+// Moves replayed by import_notation and seed_games aren't counted.
+// It's atomic so applyMove can bump it without any lock beyond the game's own.
+var movesServed atomic.Int64
+
+// gameTotals adds up finished games for get_statistics
+type gameTotals struct {
+	finished int
+	moves    int
+	draws    int
+	wins     map[string]int
+	points   map[string]int // points scored by each mark, see winPoints
+}
+
+func newGameTotals() gameTotals {
+	return gameTotals{wins: map[string]int{}, points: map[string]int{}}
+}
+
+// add: count a finished game. Caller must hold game.mu.
+func (t *gameTotals) add(game *Game) {
+	t.finished++
+	t.moves += len(game.History)
+	if game.Winner == "draw" {
+		t.draws++
+		return
+	}
+	t.wins[game.Winner]++
+	t.points[game.Winner] += game.Points
+}
+
+// merge: add totals counted elsewhere
+func (t *gameTotals) merge(o gameTotals) {
+	t.finished += o.finished
+	t.moves += o.moves
+	t.draws += o.draws
+	for m, n := range o.wins {
+		t.wins[m] += n
+	}
+	for m, n := range o.points {
+		t.points[m] += n
+	}
+}
+
+// getStatisticsRPC: global aggregates over finished games for the admin dashboard
+func getStatisticsRPC(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if !isAdmin(ctx) {
+		return "", errAdminOnly
+	}
+
+	totals, inProgress := newGameTotals(), 0
+	gamesMu.RLock()
+	// archived and evicted games have left the map but still count
+	totals.merge(archivedTotals)
+	totals.merge(evictedTotals)
+	for _, game := range games {
+		game.mu.Lock()
+		if game.Winner == "" {
+			inProgress++
+		} else {
+			totals.add(game)
+		}
+		game.mu.Unlock()
+	}
+	gamesMu.RUnlock()
+
+	// averages and rates are per finished game, 0 when nothing has finished yet
+	perGame := func(n int) float64 {
+		if totals.finished == 0 {
+			return 0
+		}
+		return float64(n) / float64(totals.finished)
+	}
+	winRates, points := map[string]float64{}, map[string]int{}
+	for _, m := range allMarks {
+		winRates[m] = perGame(totals.wins[m])
+		points[m] = totals.points[m]
+	}
+
+	resp := map[string]interface{}{
+		"ok":            true,
+		"games_played":  totals.finished,
+		"in_progress":   inProgress,
+		"average_moves": perGame(totals.moves),
+		"win_rate":      winRates,
+		"draw_rate":     perGame(totals.draws),
+		"points":        points,
+		"moves_served":  movesServed.Load(),
+	}
+	b, _ := json.Marshal(resp)
+	return string(b), nil
+}
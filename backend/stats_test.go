@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestWinKindsAndPoints(t *testing.T) {
+	setupTest(t)
+	cases := []struct {
+		name   string
+		cells  []int
+		kind   string
+		points int
+	}{
+		{"row", []int{0, 3, 1, 4, 2}, "row", 1},
+		{"column", []int{0, 1, 3, 2, 6}, "column", 1},
+		{"diagonal", []int{0, 1, 4, 2, 8}, "diagonal", 2},
+	}
+	for _, c := range cases {
+		gid := createGame(t, `{}`)
+		playCells(t, gid, c.cells...)
+		game := gameState(t, gid)
+		if game.Winner != "X" || game.WinKind != c.kind || game.Points != c.points {
+			t.Errorf("%s: winner %q kind %q points %d, want X %s %d", c.name, game.Winner, game.WinKind, game.Points, c.kind, c.points)
+		}
+	}
+}
+
+func TestStatisticsTallyDiagonalOverRow(t *testing.T) {
+	setupTest(t)
+	// X wins on a row, O wins on a diagonal
+	playCells(t, createGame(t, `{}`), 0, 3, 1, 4, 2)
+	playCells(t, createGame(t, `{}`), 1, 0, 2, 4, 3, 8)
+
+	stats := mustRPC(t, getStatisticsRPC, `{}`)
+	points := stats["points"].(map[string]interface{})
+	if points["X"] != 1.0 || points["O"] != 2.0 {
+		t.Fatalf("points = %v, want X 1 and O 2", points)
+	}
+}
+
+func TestStatisticsAggregates(t *testing.T) {
+	setupTest(t)
+	playCells(t, createGame(t, `{}`), 0, 3, 1, 4, 2)
+	playCells(t, createGame(t, `{}`), drawnGame...)
+	playCells(t, createGame(t, `{}`), 4)
+
+	resp := mustRPC(t, getStatisticsRPC, `{}`)
+	if resp["games_played"] != 2.0 || resp["in_progress"] != 1.0 || resp["average_moves"] != 7.0 || resp["draw_rate"] != 0.5 {
+		t.Fatalf("statistics = %v", resp)
+	}
+	if rates := resp["win_rate"].(map[string]interface{}); rates["X"] != 0.5 || rates["O"] != 0.0 {
+		t.Fatalf("win_rate = %v", rates)
+	}
+
+	setValue(t, &adminIDs, map[string]bool{})
+	if _, err := callRPCWith(t, userContext("player", "s1"), nil, getStatisticsRPC, `{}`); err != errAdminOnly {
+		t.Fatalf("non-admin: err = %v, want admin only", err)
+	}
+}
+
+func TestMovesServedUnderConcurrentMoves(t *testing.T) {
+	setupTest(t)
+	before := movesServed.Load()
+	gids := make([]string, 8)
+	for i := range gids {
+		gids[i] = createGame(t, `{}`)
+	}
+
+	// every goroutine races for the same cells of every game, so each cell is taken exactly once
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, gid := range gids {
+				for _, cell := range []int{4, 0, 8} {
+					makeMoveRPC(context.Background(), newTestLogger(), nil, nil, gameRequest(gid, fmt.Sprintf(`"cell":%d`, cell)))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if served := movesServed.Load() - before; served != int64(3*len(gids)) {
+		t.Fatalf("moves served = %d, want %d", served, 3*len(gids))
+	}
+	for _, gid := range gids {
+		if game := gameState(t, gid); game.Board != "O---X---X" || game.Version != 3 {
+			t.Fatalf("game %s: board %s version %d", gid, game.Board, game.Version)
+		}
+	}
+	if resp := mustRPC(t, getStatisticsRPC, `{}`); resp["moves_served"] != float64(movesServed.Load()) {
+		t.Fatalf("moves_served = %v", resp["moves_served"])
+	}
+}